@@ -0,0 +1,50 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// resolveAcceptableChecksums checks `Options.AcceptableChecksums` and sets Checksum to the
+// first of them, so that a download is treated the same as one with a single checksum until
+// it is validated.
+func resolveAcceptableChecksums(options *Options) error {
+	if len(options.AcceptableChecksums) == 0 {
+		return nil
+	}
+	if options.Checksum != "" && options.Checksum != options.AcceptableChecksums[0] {
+		return errors.New("Checksum and AcceptableChecksums are mutually exclusive")
+	}
+	if options.VerifyFromFilename || options.VerifyStoreChecksumHeader {
+		return errors.New("AcceptableChecksums cannot be combined with VerifyFromFilename or VerifyStoreChecksumHeader")
+	}
+	for _, checksum := range options.AcceptableChecksums {
+		if _, err := hex.DecodeString(checksum); err != nil || checksum == "" {
+			return errors.Errorf("invalid acceptable checksum %q: must be hex encoded", checksum)
+		}
+	}
+	options.Checksum = options.AcceptableChecksums[0]
+	return nil
+}
+
+// acceptChecksums makes cv accept any of acceptable, if there are any.
+func acceptChecksums(cv checksumValidator, acceptable []string) {
+	if v, ok := cv.(*validator); ok && len(acceptable) > 0 {
+		v.acceptable = acceptable
+	}
+}
@@ -0,0 +1,254 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+const defaultMaxConcurrency = 4
+
+// Job describes a single download to a file performed as part of a batch.
+type Job struct {
+	// Src is the URL to download.
+	Src string
+	// Dest is the file to download to.
+	Dest string
+	// Options holds the options used for this download.
+	Options FileOptions
+}
+
+// JobResult holds the outcome of a single Job.
+type JobResult struct {
+	// Job is the job this result is for.
+	Job Job
+	// Err is the error that occurred downloading the job, if any. Jobs that were queued or
+	// in-flight when the batch context was cancelled have this set to the context's error.
+	Err error
+	// Result is the result of the download if it succeeded.
+	Result Result
+}
+
+// BatchOptions holds the possible configuration options for batch downloads.
+type BatchOptions struct {
+	// MaxConcurrency is the maximum number of downloads to run at the same time. Defaults
+	// to 4 if unset.
+	MaxConcurrency int
+	// PerHostConcurrency is the maximum number of downloads to run at the same time against
+	// any single host, shared across the whole batch. Downloads from different hosts are
+	// still run in parallel, up to MaxConcurrency in total: jobs for a host that is at its
+	// limit are deferred in favour of later jobs for other hosts. Each download counts as a
+	// single connection, so jobs aren't split into concurrent ranges (see
+	// `Options.Concurrency`) if this is set. Defaults to unlimited if unset.
+	PerHostConcurrency int
+	// ProgressBars is the configuration of an aggregate progress bar showing the number of
+	// completed jobs and the total bytes downloaded across the whole batch. Set to `nil`
+	// (default) to disable. This is independent of any per-job progress bars.
+	ProgressBars *ProgressBarOptions
+	// PrefetchSizes issues a HEAD request for every job before starting any downloads so
+	// that the aggregate progress bar knows the total size of the batch up front. Without
+	// it, sizes are added to the total as each download starts. Jobs whose size cannot be
+	// determined contribute to the total as their bytes arrive.
+	PrefetchSizes bool
+	// MaxOpenFiles is the maximum number of jobs writing to files at the same time, to avoid
+	// exhausting file descriptors. A job holds its file from when it creates its temp file
+	// until it has been renamed to its destination, which spans the whole download, so this
+	// also limits the number of connections. Jobs waiting for a file occupy one of the
+	// MaxConcurrency slots but hold no connection. Defaults to unlimited if unset.
+	MaxOpenFiles int
+}
+
+// ToFiles downloads all of the specified `jobs` using the specified `BatchOptions`.
+// Results are returned in the same order as `jobs`, along with an error aggregating all
+// failed jobs.
+func ToFiles(jobs []Job, options BatchOptions) ([]JobResult, error) {
+	return ToFilesContext(context.Background(), jobs, options)
+}
+
+// ToFilesContext downloads all of the specified `jobs` using the specified `BatchOptions`.
+// Cancelling `ctx` aborts all in-flight downloads, cleaning up their temp files, and
+// prevents any queued downloads from starting.
+func ToFilesContext(ctx context.Context, jobs []Job, options BatchOptions) ([]JobResult, error) {
+	results := make([]JobResult, len(jobs))
+
+	concurrency := options.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultMaxConcurrency
+	}
+	if concurrency > len(jobs) {
+		concurrency = len(jobs)
+	}
+
+	openFiles := newSemaphore(options.MaxOpenFiles)
+	if openFiles != nil || options.PerHostConcurrency > 0 {
+		attached := make([]Job, len(jobs))
+		for i, job := range jobs {
+			job.Options.openFiles = openFiles
+			job.Options.sequential = options.PerHostConcurrency > 0
+			attached[i] = job
+		}
+		jobs = attached
+	}
+
+	var progress *batchProgress
+	if options.ProgressBars != nil {
+		var sizes []int64
+		if options.PrefetchSizes {
+			sizes = prefetchSizes(ctx, jobs, concurrency)
+		}
+		progress = newBatchProgress(len(jobs), sizes, options.ProgressBars)
+		jobs = progress.attach(jobs)
+		defer progress.finish()
+	}
+
+	hosts := newHostLimiter(jobs, options.PerHostConcurrency)
+	finished := make(chan int)
+	pending := make([]int, len(jobs))
+	for i := range pending {
+		pending[i] = i
+	}
+	cancelled := ctx.Done()
+	for running := 0; len(pending) > 0 || running > 0; {
+		// Start the pending jobs in order, skipping those whose host is at its limit.
+		deferred := pending[:0]
+		for _, idx := range pending {
+			if running == concurrency || !hosts.acquire(idx) {
+				deferred = append(deferred, idx)
+				continue
+			}
+			running++
+			go func(idx int) {
+				results[idx] = runJob(ctx, jobs[idx])
+				finished <- idx
+			}(idx)
+		}
+		pending = deferred
+
+		select {
+		case idx := <-finished:
+			running--
+			hosts.release(idx)
+			progress.jobDone()
+		case <-cancelled:
+			for _, idx := range pending {
+				results[idx] = JobResult{Job: jobs[idx], Err: ctx.Err()}
+			}
+			pending, cancelled = nil, nil
+		}
+	}
+
+	var res *multierror.Error
+	for _, result := range results {
+		if result.Err != nil {
+			res = multierror.Append(res, errors.Wrapf(result.Err, "failed to download %s", result.Job.Src))
+		}
+	}
+	return results, res.ErrorOrNil()
+}
+
+func runJob(ctx context.Context, job Job) JobResult {
+	if err := ctx.Err(); err != nil {
+		return JobResult{Job: job, Err: err}
+	}
+	result, err := toFile(ctx, job.Src, job.Dest, job.Options)
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return JobResult{Job: job, Err: err, Result: result}
+}
+
+func jobHost(job Job) string {
+	u, err := parseSrc(job.Src, job.Options.Vars)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// semaphore limits the number of holders at once. A nil *semaphore imposes no limit.
+type semaphore chan struct{}
+
+func newSemaphore(limit int) *semaphore {
+	if limit <= 0 {
+		return nil
+	}
+	s := make(semaphore, limit)
+	return &s
+}
+
+func (s *semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case *s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *semaphore) release() {
+	if s == nil {
+		return
+	}
+	<-*s
+}
+
+// hostLimiter limits the number of concurrent downloads per host. A nil *hostLimiter
+// imposes no limit. It is only used by the goroutine dispatching the jobs of a batch.
+type hostLimiter struct {
+	limit   int
+	hosts   []string
+	running map[string]int
+}
+
+func newHostLimiter(jobs []Job, limit int) *hostLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	l := &hostLimiter{
+		limit:   limit,
+		hosts:   make([]string, len(jobs)),
+		running: map[string]int{},
+	}
+	for i, job := range jobs {
+		l.hosts[i] = jobHost(job)
+	}
+	return l
+}
+
+// acquire returns true, counting the job at idx against its host, if its host has capacity.
+func (l *hostLimiter) acquire(idx int) bool {
+	if l == nil {
+		return true
+	}
+	host := l.hosts[idx]
+	if l.running[host] == l.limit {
+		return false
+	}
+	l.running[host]++
+	return true
+}
+
+func (l *hostLimiter) release(idx int) {
+	if l == nil {
+		return
+	}
+	l.running[l.hosts[idx]]--
+}
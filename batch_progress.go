@@ -0,0 +1,205 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	pb "gopkg.in/cheggaaa/pb.v1"
+)
+
+// batchProgress renders a single progress bar aggregating all jobs in a batch.
+type batchProgress struct {
+	bar       *pb.ProgressBar
+	jobs      int
+	completed int32
+	sizes     []int64
+
+	mu    sync.Mutex
+	total int64
+}
+
+func newBatchProgress(jobs int, sizes []int64, options *ProgressBarOptions) *batchProgress {
+	var total int64
+	for _, size := range sizes {
+		if size > 0 {
+			total += size
+		}
+	}
+	p := &batchProgress{
+		bar:   newProgressBar(total, options.MaxWidth, options.Writer),
+		jobs:  jobs,
+		sizes: sizes,
+		total: total,
+	}
+	p.bar.Prefix(p.prefix(0))
+	p.bar.Start()
+	return p
+}
+
+// attach returns a copy of jobs with each job's options set to report to p.
+func (p *batchProgress) attach(jobs []Job) []Job {
+	attached := make([]Job, len(jobs))
+	for i, job := range jobs {
+		jp := &jobProgress{batch: p}
+		if i < len(p.sizes) && p.sizes[i] > 0 {
+			jp.counted = true
+		}
+		job.Options.batchProgress = jp
+		attached[i] = job
+	}
+	return attached
+}
+
+func (p *batchProgress) prefix(completed int32) string {
+	return fmt.Sprintf("%d/%d ", completed, p.jobs)
+}
+
+func (p *batchProgress) jobDone() {
+	if p == nil {
+		return
+	}
+	p.bar.Prefix(p.prefix(atomic.AddInt32(&p.completed, 1)))
+}
+
+func (p *batchProgress) finish() {
+	p.bar.Finish()
+}
+
+func (p *batchProgress) addTotal(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total += n
+	p.bar.SetTotal64(p.total)
+}
+
+// jobProgress tracks the contribution of a single job to the aggregate progress bar.
+type jobProgress struct {
+	batch *batchProgress
+
+	mu      sync.Mutex
+	counted bool
+	read    int64
+}
+
+// proxyReader wraps the body of a response for this job. If the job has been retried, any
+// bytes counted from previous attempts are first removed from the aggregate.
+func (jp *jobProgress) proxyReader(r io.Reader, contentLength int64) io.Reader {
+	jp.start(contentLength)
+	return &jobProgressReader{r: r, jp: jp}
+}
+
+// start starts an attempt to download contentLength bytes for this job, removing any bytes
+// counted from previous attempts from the aggregate.
+func (jp *jobProgress) start(contentLength int64) {
+	jp.mu.Lock()
+	defer jp.mu.Unlock()
+	jp.batch.bar.Add64(-jp.read)
+	if !jp.counted {
+		jp.batch.addTotal(-jp.read)
+		if contentLength > 0 {
+			jp.batch.addTotal(contentLength)
+			jp.counted = true
+		}
+	}
+	jp.read = 0
+}
+
+func (jp *jobProgress) add(n int64) {
+	jp.mu.Lock()
+	defer jp.mu.Unlock()
+	jp.read += n
+	if !jp.counted {
+		jp.batch.addTotal(n)
+	}
+	jp.batch.bar.Add64(n)
+}
+
+type jobProgressReader struct {
+	r  io.Reader
+	jp *jobProgress
+}
+
+func (r *jobProgressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.jp.add(int64(n))
+	return n, err
+}
+
+// prefetchSizes issues a HEAD request for each job, returning the reported Content-Length
+// of each (or -1 if unknown).
+func prefetchSizes(ctx context.Context, jobs []Job, concurrency int) []int64 {
+	sizes := make([]int64, len(jobs))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range queue {
+				sizes[idx] = headContentLength(ctx, jobs[idx].Src, jobs[idx].Options.Options)
+			}
+		}()
+	}
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return sizes
+}
+
+// headContentLength issues a HEAD request for src, returning its reported Content-Length or
+// -1 if unknown. No request is made for Offline jobs.
+func headContentLength(ctx context.Context, src string, options Options) int64 {
+	if options.Offline {
+		return -1
+	}
+	u, err := parseSrc(src, options.Vars)
+	if err != nil {
+		return -1
+	}
+	u = localFileURL(u)
+	if err = checkScheme(u); err != nil {
+		return -1
+	}
+	ctx, cancel := withTimeout(ctx, &options)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return -1
+	}
+	setRequestHeaders(req, options)
+	req = req.WithContext(ctx)
+	if options.SignRequest != nil {
+		if err = options.SignRequest(req); err != nil {
+			return -1
+		}
+	}
+	resp, err := getHTTPClient(options).Do(req)
+	if err != nil {
+		return -1
+	}
+	_ = resp.Body.Close() // #nosec
+	if resp.StatusCode != http.StatusOK {
+		return -1
+	}
+	return contentLength(resp, options)
+}
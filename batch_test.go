@@ -0,0 +1,486 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	download "github.com/jimmidyson/go-download"
+)
+
+func TestDownloadToFilesSuccess(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	var jobs []download.Job
+	for i := 0; i < 10; i++ {
+		jobs = append(jobs, download.Job{
+			Src:  srv.URL + "/testfile",
+			Dest: filepath.Join(targetDir, "testfile"+strconv.Itoa(i)),
+		})
+	}
+
+	results, err := download.ToFiles(jobs, download.BatchOptions{MaxConcurrency: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != len(jobs) {
+		t.Fatalf("wrong number of results, expected %d, actual %d", len(jobs), len(results))
+	}
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i, result := range results {
+		if result.Job.Dest != jobs[i].Dest {
+			t.Fatalf("results out of order, expected %s, actual %s", jobs[i].Dest, result.Job.Dest)
+		}
+		downloadedData, err := ioutil.ReadFile(result.Job.Dest)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(testData, downloadedData) {
+			t.Fatal("wrong downloaded data")
+		}
+	}
+}
+
+func TestDownloadToFilesContextCancel(t *testing.T) {
+	started := make(chan struct{}, 10)
+	hf := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-req.Context().Done()
+	})
+	srv := httptest.NewServer(hf)
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	var jobs []download.Job
+	for i := 0; i < 10; i++ {
+		jobs = append(jobs, download.Job{
+			Src:  srv.URL + "/testfile",
+			Dest: filepath.Join(targetDir, "testfile"+strconv.Itoa(i)),
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		<-started
+		cancel()
+	}()
+
+	done := make(chan struct{})
+	var results []download.JobResult
+	go func() {
+		defer close(done)
+		results, err = download.ToFilesContext(ctx, jobs, download.BatchOptions{MaxConcurrency: 2})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for cancelled batch to return")
+	}
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, result := range results {
+		if result.Err != context.Canceled {
+			t.Fatalf("unexpected error, expected: '%v', actual: '%v'", context.Canceled, result.Err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(targetDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected temp files to be cleaned up, found %d files", len(files))
+	}
+}
+
+func TestDownloadToFilesPerHostConcurrency(t *testing.T) {
+	var (
+		mu                  sync.Mutex
+		inFlight, maxFlight int
+	)
+	hfs := http.FileServer(http.Dir("testdata"))
+	hf := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxFlight {
+			maxFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(20 * time.Millisecond)
+		hfs.ServeHTTP(w, req)
+	})
+	srv := httptest.NewServer(hf)
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	var jobs []download.Job
+	for i := 0; i < 8; i++ {
+		jobs = append(jobs, download.Job{
+			Src:  srv.URL + "/testfile",
+			Dest: filepath.Join(targetDir, "testfile"+strconv.Itoa(i)),
+		})
+	}
+
+	_, err = download.ToFiles(jobs, download.BatchOptions{MaxConcurrency: 4, PerHostConcurrency: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if maxFlight > 2 {
+		t.Fatalf("too many concurrent requests to host, expected at most %d, actual %d", 2, maxFlight)
+	}
+}
+
+func TestDownloadToFilesPerHostConcurrencyOtherHosts(t *testing.T) {
+	otherStarted := make(chan struct{})
+	var waited int32
+	hfs := http.FileServer(http.Dir("testdata"))
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-otherStarted:
+		case <-time.After(5 * time.Second):
+			atomic.StoreInt32(&waited, 1)
+		}
+		hfs.ServeHTTP(w, req)
+	}))
+	defer busy.Close()
+	var once sync.Once
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		once.Do(func() { close(otherStarted) })
+		hfs.ServeHTTP(w, req)
+	}))
+	defer other.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	var jobs []download.Job
+	for i, srv := range []*httptest.Server{busy, busy, busy, busy, other} {
+		jobs = append(jobs, download.Job{
+			Src:  srv.URL + "/testfile",
+			Dest: filepath.Join(targetDir, "testfile"+strconv.Itoa(i)),
+		})
+	}
+
+	_, err = download.ToFiles(jobs, download.BatchOptions{MaxConcurrency: 2, PerHostConcurrency: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(&waited) != 0 {
+		t.Fatal("expected the job for the other host to start while the busy host was at its limit")
+	}
+}
+
+func TestDownloadToFilesPerHostConcurrencyRanges(t *testing.T) {
+	var (
+		mu                  sync.Mutex
+		inFlight, maxFlight int
+	)
+	hfs := http.FileServer(http.Dir("testdata"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxFlight {
+			maxFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(20 * time.Millisecond)
+		hfs.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	_, err = download.ToFiles([]download.Job{{
+		Src:  srv.URL + "/testfile",
+		Dest: filepath.Join(targetDir, "testfile"),
+		Options: download.FileOptions{
+			Options: download.Options{Concurrency: 3},
+		},
+	}}, download.BatchOptions{PerHostConcurrency: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if maxFlight > 1 {
+		t.Fatalf("too many concurrent requests to host, expected at most %d, actual %d", 1, maxFlight)
+	}
+}
+
+func TestDownloadToFilesProgressBars(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	var jobs []download.Job
+	for i := 0; i < 3; i++ {
+		jobs = append(jobs, download.Job{
+			Src:  srv.URL + "/testfile",
+			Dest: filepath.Join(targetDir, "testfile"+strconv.Itoa(i)),
+		})
+	}
+
+	for _, prefetch := range []bool{false, true} {
+		var buf bytes.Buffer
+		_, err = download.ToFiles(jobs, download.BatchOptions{
+			ProgressBars:  &download.ProgressBarOptions{Writer: &buf},
+			PrefetchSizes: prefetch,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(buf.String(), "3/3") {
+			t.Fatalf("expected progress output to contain: '%s', actual: '%s'", "3/3", buf.String())
+		}
+	}
+}
+
+func TestDownloadToFilesProgressBarsConcurrency(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	var jobs []download.Job
+	for i := 0; i < 3; i++ {
+		jobs = append(jobs, download.Job{
+			Src:  srv.URL + "/testfile",
+			Dest: filepath.Join(targetDir, "testfile"+strconv.Itoa(i)),
+			Options: download.FileOptions{
+				Options: download.Options{Concurrency: 4},
+			},
+		})
+	}
+
+	var buf bytes.Buffer
+	_, err = download.ToFiles(jobs, download.BatchOptions{
+		ProgressBars: &download.ProgressBarOptions{Writer: &buf},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "18 B / 18 B") {
+		t.Fatalf("expected progress output to contain: '%s', actual: '%s'", "18 B / 18 B", buf.String())
+	}
+}
+
+func TestDownloadToFilesPrefetchSizesOffline(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	dest := filepath.Join(targetDir, "testfile")
+	err = ioutil.WriteFile(dest, []byte("12345\n"), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = download.ToFiles([]download.Job{{
+		Src:  srv.URL + "/testfile",
+		Dest: dest,
+		Options: download.FileOptions{
+			Options: download.Options{
+				Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+				Offline:  true,
+			},
+		},
+	}}, download.BatchOptions{
+		ProgressBars:  &download.ProgressBarOptions{Writer: ioutil.Discard},
+		PrefetchSizes: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("expected no requests for offline jobs, got %d", n)
+	}
+}
+
+func TestDownloadToFilesPrefetchSizesVars(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		heads []string
+	)
+	hfs := http.FileServer(http.Dir("testdata"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			mu.Lock()
+			heads = append(heads, req.URL.Path)
+			mu.Unlock()
+		}
+		hfs.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	_, err = download.ToFiles([]download.Job{{
+		Src:  srv.URL + "/{file}",
+		Dest: filepath.Join(targetDir, "testfile"),
+		Options: download.FileOptions{
+			Options: download.Options{Vars: map[string]string{"file": "testfile"}},
+		},
+	}}, download.BatchOptions{
+		ProgressBars:  &download.ProgressBarOptions{Writer: ioutil.Discard},
+		PrefetchSizes: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(heads) != 1 || heads[0] != "/testfile" {
+		t.Fatalf("expected a HEAD request for the expanded URL, got: %v", heads)
+	}
+}
+
+func TestDownloadToFilesMaxOpenFiles(t *testing.T) {
+	var (
+		mu                  sync.Mutex
+		inFlight, maxFlight int
+	)
+	hfs := http.FileServer(http.Dir("testdata"))
+	hf := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxFlight {
+			maxFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(20 * time.Millisecond)
+		hfs.ServeHTTP(w, req)
+	})
+	srv := httptest.NewServer(hf)
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	var jobs []download.Job
+	for i := 0; i < 10; i++ {
+		jobs = append(jobs, download.Job{
+			Src:  srv.URL + "/testfile",
+			Dest: filepath.Join(targetDir, "testfile"+strconv.Itoa(i)),
+		})
+	}
+
+	results, err := download.ToFiles(jobs, download.BatchOptions{MaxConcurrency: 5, MaxOpenFiles: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, result := range results {
+		if _, err = os.Stat(result.Job.Dest); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if maxFlight > 2 {
+		t.Fatalf("too many files open at once, expected at most %d, actual %d", 2, maxFlight)
+	}
+}
@@ -0,0 +1,75 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"crypto"
+	"hash"
+	"io"
+	"sync"
+)
+
+// bufferPools holds a pool of copy buffers for each configured `Options.BufferSize`.
+var bufferPools sync.Map
+
+// hasherPools holds a pool of hashers for each hash function, used when
+// `Options.BufferSize` is configured.
+var hasherPools sync.Map
+
+func getBuffer(size int) *[]byte {
+	pool, _ := bufferPools.LoadOrStore(size, &sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, size)
+			return &b
+		},
+	})
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+func putBuffer(b *[]byte) {
+	if pool, ok := bufferPools.Load(len(*b)); ok {
+		pool.(*sync.Pool).Put(b)
+	}
+}
+
+func getHasher(hashType crypto.Hash) (hash.Hash, error) {
+	if pool, ok := hasherPools.Load(hashType); ok {
+		if h, ok := pool.(*sync.Pool).Get().(hash.Hash); ok {
+			return h, nil
+		}
+	}
+	return newHasher(hashType)
+}
+
+func putHasher(hashType crypto.Hash, h hash.Hash) {
+	h.Reset()
+	pool, _ := hasherPools.LoadOrStore(hashType, &sync.Pool{})
+	pool.(*sync.Pool).Put(h)
+}
+
+// copyBuffer copies from src to w using a pooled buffer of size bytes.
+func copyBuffer(w io.Writer, src io.Reader, size int) (int64, error) {
+	buf := getBuffer(size)
+	defer putBuffer(buf)
+	// Hide any ReaderFrom implementation of w, which would allocate its own buffer.
+	return io.CopyBuffer(struct{ io.Writer }{w}, src, *buf)
+}
+
+// releaseValidator returns the hasher of cv to the pool once cv is no longer used.
+func releaseValidator(cv checksumValidator) {
+	if v, ok := cv.(*validator); ok {
+		putHasher(v.hashType, v.hasher)
+	}
+}
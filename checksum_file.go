@@ -0,0 +1,148 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"crypto"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var checksumFileExtensions = map[crypto.Hash]string{
+	crypto.MD5:         "md5",
+	crypto.SHA1:        "sha1",
+	crypto.SHA224:      "sha224",
+	crypto.SHA256:      "sha256",
+	crypto.SHA384:      "sha384",
+	crypto.SHA512:      "sha512",
+	crypto.SHA3_256:    "sha3-256",
+	crypto.SHA3_512:    "sha3-512",
+	crypto.BLAKE2b_512: "blake2b-512",
+}
+
+// checksumFileHasher computes the checksum for a checksum file.
+type checksumFileHasher struct {
+	hash.Hash
+	hashType crypto.Hash
+	// sum is set instead of writing to Hash if the checksum validator has already computed
+	// the checksum of the bytes written.
+	sum []byte
+	// prefix is the marshaled state of Hash after hashing the existing bytes of a resumed
+	// download, which it is reset to before each attempt.
+	prefix []byte
+	// written is the number of bytes written since the last reset.
+	written int64
+	// partial is set for the hasher tracking the checksum of a resumed partial download,
+	// which must always be written to.
+	partial bool
+}
+
+func (h *checksumFileHasher) Write(p []byte) (int, error) {
+	h.written += int64(len(p))
+	return h.Hash.Write(p)
+}
+
+func (h *checksumFileHasher) reset() error {
+	h.Reset()
+	h.sum = nil
+	h.written = 0
+	if h.prefix != nil {
+		if err := h.Hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(h.prefix); err != nil {
+			return errors.Wrap(err, "failed to restore hash state")
+		}
+	}
+	return nil
+}
+
+// savePrefix records the current state of Hash as the state to reset to.
+func (h *checksumFileHasher) savePrefix() error {
+	m, ok := h.Hash.(encoding.BinaryMarshaler)
+	if !ok {
+		return errors.New("hash does not support saving its state")
+	}
+	prefix, err := m.MarshalBinary()
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal hash state")
+	}
+	h.prefix = prefix
+	return nil
+}
+
+func (h *checksumFileHasher) digest() []byte {
+	if h.sum != nil {
+		return h.sum
+	}
+	return h.Sum(nil)
+}
+
+// newChecksumFileHashers returns a hasher for each distinct hash in hashTypes, failing if any
+// of them is unavailable before anything is downloaded.
+func newChecksumFileHashers(hashTypes []crypto.Hash) ([]*checksumFileHasher, error) {
+	var hashers []*checksumFileHasher
+	seen := map[crypto.Hash]bool{}
+	for _, hashType := range hashTypes {
+		if hashType == 0 {
+			hashType = crypto.SHA256
+		}
+		if seen[hashType] {
+			continue
+		}
+		seen[hashType] = true
+		hasher, err := newHasher(hashType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unsupported checksum file hash %v", hashType)
+		}
+		hashers = append(hashers, &checksumFileHasher{Hash: hasher, hashType: hashType})
+	}
+	return hashers, nil
+}
+
+// ChecksumFileName returns the name of the checksum file for `artifact` written by
+// `FileOptions.WriteChecksumFile` for `hashType`, e.g. `artifact.sha256`. Hashes without a
+// conventional extension use their lower case name. A zero `hashType` defaults to SHA256.
+func ChecksumFileName(artifact string, hashType crypto.Hash) string {
+	if hashType == 0 {
+		hashType = crypto.SHA256
+	}
+	ext, ok := checksumFileExtensions[hashType]
+	if !ok {
+		ext = strings.ToLower(strings.Replace(hashType.String(), "/", "-", -1))
+	}
+	return artifact + "." + ext
+}
+
+// FormatChecksumLine returns a checksum file line for the hex encoded `digest` of `filename`
+// computed with `hashType`, in the `CHECKSUM  FILENAME` format written by sha256sum and
+// friends. Such lines are both written by `FileOptions.WriteChecksumFile` and parsed when
+// validating checksums. The format doesn't record the hash, so the line must be verified with
+// `Options.ChecksumHash` set to `hashType` unless it is implied by the length of `digest`.
+func FormatChecksumLine(hashType crypto.Hash, digest, filename string) string {
+	return fmt.Sprintf("%s  %s\n", strings.ToLower(digest), filename)
+}
+
+func writeChecksumFile(dest string, hashType crypto.Hash, digest []byte) error {
+	line := FormatChecksumLine(hashType, hex.EncodeToString(digest), filepath.Base(dest))
+	if err := ioutil.WriteFile(ChecksumFileName(dest, hashType), []byte(line), 0600); err != nil {
+		return errors.Wrap(err, "failed to write checksum file")
+	}
+	return nil
+}
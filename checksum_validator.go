@@ -17,81 +17,165 @@ package download
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto"
 	"encoding/hex"
+	"fmt"
 	"hash"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
 )
 
+// ErrSizeMismatch is returned (wrapped) when the number of bytes downloaded differs from the
+// size listed in the checksum file or the Content-Length of the response.
+var ErrSizeMismatch = errors.New("size mismatch")
+
+// ErrChecksumFormat is returned (wrapped) when a checksum, or the checksum file or header it
+// is read from, can't be parsed.
+var ErrChecksumFormat = errors.New("invalid checksum format")
+
+// formatError marks an error as being caused by an unparseable checksum.
+type formatError struct {
+	err error
+}
+
+func (e *formatError) Error() string {
+	return e.err.Error()
+}
+
+func (e *formatError) Unwrap() error {
+	return e.err
+}
+
+func (e *formatError) Is(target error) bool {
+	return target == ErrChecksumFormat
+}
+
+// checksumFormatError returns an error formatted from format and args, marked with
+// ErrChecksumFormat.
+func checksumFormatError(format string, args ...interface{}) error {
+	return &formatError{errors.Errorf(format, args...)}
+}
+
 type checksumValidator interface {
 	io.Writer
-	validate() bool
+	validate() error
 }
 
-func newValidator(hasher hash.Hash, client *http.Client, checksum, filename string) (checksumValidator, error) {
+func newValidator(ctx context.Context, hasher hash.Hash, client *http.Client, checksum, filename string, options Options, progress *ProgressBarOptions) (checksumValidator, error) {
+	sri, err := newSRIValidator(checksum)
+	if err != nil {
+		return nil, err
+	}
+	if sri != nil {
+		return sri, nil
+	}
+
 	if u, err := url.Parse(checksum); err == nil && len(u.Scheme) != 0 {
-		if u.Scheme == "http" || u.Scheme == "https" {
-			return newValidatorFromChecksumURL(hasher, client, checksum, filename)
+		if u.Scheme == "http" || u.Scheme == "https" || registeredFetcher(u.Scheme) != nil {
+			return newValidatorFromChecksumURL(ctx, hasher, client, checksum, filename, options, progress)
 		}
 
-		return nil, errors.Errorf("unsupported scheme: %s (supported schemes: %v)", u.Scheme, []string{"http", "https"})
+		return nil, errors.Wrapf(ErrUnsupportedScheme, "checksum URL scheme %s (supported schemes: %v)", u.Scheme, []string{"http", "https"})
 	}
 
 	if _, err := hex.DecodeString(checksum); err == nil {
 		return &validator{
 			hasher:   hasher,
 			checksum: checksum,
+			size:     -1,
 		}, nil
 	}
 
 	if f, err := os.Open(checksum); err == nil {
 		defer func() { _ = f.Close() }() // #nosec
-		return newValidatorFromReader(hasher, f, filename)
+		return newValidatorFromReader(hasher, f, filename, options.ChecksumFilenameMatcher)
 	}
 
-	return nil, errors.New("invalid checksum: must be one of hex encoded checksum, URL or file path")
+	return nil, checksumFormatError("invalid checksum: must be one of hex encoded checksum, URL or file path")
 }
 
-func newValidatorFromChecksumURL(hasher hash.Hash, client *http.Client, checksumURL, filename string) (checksumValidator, error) {
-	resp, err := client.Get(checksumURL)
+// newValidatorFromChecksumURL downloads the checksum file at checksumURL, showing a progress
+// bar labelled `checksum` if progress is non-nil and the size of the checksum file is known.
+// The request is made with the headers and signing of options and is aborted when ctx is
+// done.
+func newValidatorFromChecksumURL(ctx context.Context, hasher hash.Hash, client *http.Client, checksumURL, filename string, options Options, progress *ProgressBarOptions) (checksumValidator, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checksumURL, nil)
 	if err != nil {
+		return nil, errors.Wrap(err, "failed to create checksum file request")
+	}
+	setRequestHeaders(req, options)
+	if options.SignRequest != nil {
+		if err = options.SignRequest(req); err != nil {
+			return nil, errors.Wrap(err, "failed to sign checksum file request")
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, errors.Wrap(err, "failed to download checksum file")
 	}
 	defer func() { _ = resp.Body.Close() }() // #nosec
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("failed to download checksum file: received status code %d", resp.StatusCode)
+		return nil, errors.Wrap(newStatusError(resp, fmt.Sprintf("expected %d", http.StatusOK)), "failed to download checksum file")
 	}
 
-	return newValidatorFromReader(hasher, resp.Body, filename)
+	var reader io.Reader = resp.Body
+	if progress != nil && resp.ContentLength > 0 {
+		bar := newProgressBar(resp.ContentLength, progress.MaxWidth, progress.Writer)
+		bar.Prefix("checksum ")
+		bar.Start()
+		defer bar.Finish()
+		// Read the whole checksum file so that the bar completes even if the matching line
+		// is found early.
+		b, err := ioutil.ReadAll(bar.NewProxyReader(reader))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to download checksum file")
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	return newValidatorFromReader(hasher, reader, filename, options.ChecksumFilenameMatcher)
 }
 
-func newValidatorFromReader(hasher hash.Hash, reader io.Reader, filename string) (checksumValidator, error) {
+// newValidatorFromReader returns a validator for the entry for filename in the checksum file
+// read from reader, matching entries with match, or exactly if match is nil.
+func newValidatorFromReader(hasher hash.Hash, reader io.Reader, filename string, match ChecksumFilenameMatcher) (checksumValidator, error) {
+	if match == nil {
+		match = exactFilenameMatcher
+	}
 	scanner := bufio.NewScanner(reader)
-	var b bytes.Buffer
+	var (
+		b     bytes.Buffer
+		found *validator
+	)
 	for scanner.Scan() {
 		line := scanner.Text()
 		spl := strings.Fields(line)
-		if len(spl) == 2 {
-			if spl[1] == filename {
-				trimmedHash := strings.TrimSpace(spl[0])
-				if _, err := hex.DecodeString(trimmedHash); err == nil {
-					return &validator{
-						hasher:   hasher,
-						checksum: trimmedHash,
-					}, nil
-				}
+		if v := parseChecksumLine(hasher, spl, filename, match); v != nil {
+			if found != nil && (!strings.EqualFold(found.checksum, v.checksum) || found.size != v.size) {
+				return nil, checksumFormatError("ambiguous checksum file: conflicting entries for %s", filename)
 			}
+			found = v
+			continue
 		}
 		if b.Len() == 0 {
 			_, _ = b.WriteString(line) // #nosec
 		}
 	}
+	if found != nil {
+		return found, nil
+	}
 	buf := b.String()
 	if len(buf) > 0 {
 		trimmedHash := strings.TrimSpace(buf)
@@ -99,24 +183,84 @@ func newValidatorFromReader(hasher hash.Hash, reader io.Reader, filename string)
 			return &validator{
 				hasher:   hasher,
 				checksum: trimmedHash,
+				size:     -1,
 			}, nil
 		}
 	}
 
-	return nil, errors.New("failed to retrieve checksum")
+	return nil, checksumFormatError("failed to retrieve checksum")
+}
+
+// parseChecksumLine returns a validator for the fields of a checksum file line if it is for
+// filename according to match, or nil otherwise. Lines are either of the format
+// `CHECKSUM FILENAME` or `CHECKSUM SIZE FILENAME`.
+func parseChecksumLine(hasher hash.Hash, fields []string, filename string, match ChecksumFilenameMatcher) *validator {
+	if len(fields) < 2 || len(fields) > 3 || !match(fields[len(fields)-1], filename) {
+		return nil
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return nil
+	}
+	size := int64(-1)
+	if len(fields) == 3 {
+		var err error
+		if size, err = strconv.ParseInt(fields[1], 10, 64); err != nil || size < 0 {
+			return nil
+		}
+	}
+	return &validator{
+		hasher:   hasher,
+		checksum: fields[0],
+		size:     size,
+	}
+}
+
+// ChecksumFilenameMatcher reports whether the filename `entryName` of an entry in a checksum
+// file refers to `wantName`, the file name of the download.
+type ChecksumFilenameMatcher func(entryName, wantName string) bool
+
+// exactFilenameMatcher is the default ChecksumFilenameMatcher, matching names exactly.
+func exactFilenameMatcher(entryName, wantName string) bool {
+	return entryName == wantName
 }
 
 var _ checksumValidator = &validator{}
 
 type validator struct {
-	hasher   hash.Hash
+	hasher hash.Hash
+	// hashType is the hash function of hasher, 0 for the default SHA256.
+	hashType crypto.Hash
 	checksum string
+	// size is the expected size, or -1 if unknown.
+	size    int64
+	written int64
+	// acceptable lists the checksums that are accepted in place of checksum, if any.
+	acceptable []string
 }
 
-func (v *validator) validate() bool {
-	return hex.EncodeToString(v.hasher.Sum(nil)) == v.checksum
+func (v *validator) validate() error {
+	if v.size >= 0 && v.written != v.size {
+		return errors.Wrapf(ErrSizeMismatch, "downloaded %d bytes, expected %d bytes", v.written, v.size)
+	}
+	sum := hex.EncodeToString(v.hasher.Sum(nil))
+	if len(v.acceptable) > 0 {
+		for _, checksum := range v.acceptable {
+			if strings.EqualFold(sum, checksum) {
+				return nil
+			}
+		}
+		return &ChecksumMismatchError{Expected: v.acceptable, Actual: sum}
+	}
+	if !strings.EqualFold(sum, v.checksum) {
+		return &ChecksumMismatchError{Expected: []string{v.checksum}, Actual: sum}
+	}
+	return nil
 }
 
 func (v *validator) Write(p []byte) (n int, err error) {
+	v.written += int64(len(p))
+	if v.size >= 0 && v.written > v.size {
+		return 0, errors.Wrapf(ErrSizeMismatch, "downloaded more than expected %d bytes", v.size)
+	}
 	return v.hasher.Write(p)
 }
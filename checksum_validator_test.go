@@ -15,12 +15,16 @@
 package download
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
 	"strings"
 	"testing"
 )
 
 func TestNewValidatorWithInvalidChecksum(t *testing.T) {
-	_, err := newValidator(nil, nil, "totally invalid", "")
+	_, err := newValidator(context.Background(), nil, nil, "totally invalid", "", Options{}, nil)
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -28,3 +32,68 @@ func TestNewValidatorWithInvalidChecksum(t *testing.T) {
 		t.Fatalf("wrong error returned, expected to start with '%s', received '%v'", "invalid checksum", err)
 	}
 }
+
+func TestNewValidatorFromReaderWithSize(t *testing.T) {
+	manifest := "1234 6 someotherfile\nf33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95 6 testfile\n"
+	for _, tc := range []struct {
+		content string
+		err     error
+	}{
+		{"12345\n", nil},
+		{"12345", ErrSizeMismatch},
+		{"12345\n\n", ErrSizeMismatch},
+	} {
+		v, err := newValidatorFromReader(sha256.New(), strings.NewReader(manifest), "testfile", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = io.Copy(v, strings.NewReader(tc.content))
+		if err == nil {
+			err = v.validate()
+		}
+		if tc.err == nil && err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tc.err != nil && !errors.Is(err, tc.err) {
+			t.Fatalf("unexpected error, expected: '%v', actual: '%v'", tc.err, err)
+		}
+	}
+}
+
+func TestNewValidatorFromReaderWithDuplicateEntries(t *testing.T) {
+	for _, tc := range []struct {
+		manifest string
+		ok       bool
+	}{
+		{"f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95  testfile\nf33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95  testfile\n", true},
+		{"f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95  testfile\n1234  other\n0000000000000000000000000000000000000000000000000000000000000000  testfile\n", false},
+		{"f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95  testfile\nf33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95 7 testfile\n", false},
+	} {
+		_, err := newValidatorFromReader(sha256.New(), strings.NewReader(tc.manifest), "testfile", nil)
+		if tc.ok && err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !tc.ok && (err == nil || !strings.HasPrefix(err.Error(), "ambiguous checksum file")) {
+			t.Fatalf("wrong error returned, expected to start with '%s', received '%v'", "ambiguous checksum file", err)
+		}
+	}
+}
+
+func TestNewValidatorFromReaderWithFilenameMatcher(t *testing.T) {
+	manifest := "0000000000000000000000000000000000000000000000000000000000000000  other/testfile\n" +
+		"f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95  ./dist/testfile\n"
+	if _, err := newValidatorFromReader(sha256.New(), strings.NewReader(manifest), "testfile", nil); err == nil {
+		t.Fatal("expected error")
+	}
+
+	match := func(entryName, wantName string) bool {
+		return strings.TrimPrefix(entryName, "./") == "dist/"+wantName
+	}
+	v, err := newValidatorFromReader(sha256.New(), strings.NewReader(manifest), "testfile", match)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if checksum := v.(*validator).checksum; checksum != "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95" {
+		t.Fatalf("wrong checksum selected: %s", checksum)
+	}
+}
@@ -0,0 +1,180 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"crypto"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ChecksumSpec is a checksum to validate a download against, see `Options.Checksums`.
+type ChecksumSpec struct {
+	// Hash is the hash for the checksum. If unspecified, defaults to SHA256.
+	Hash crypto.Hash
+	// Checksum is either a checksum string, or a URL or path to a file containing the
+	// checksum, as for `Options.Checksum`.
+	Checksum string
+}
+
+// resolveChecksums checks `Options.Checksums` and, unless Checksum is already set, moves the
+// first of them to Checksum and ChecksumHash, so that it is validated the same as a single
+// checksum and the rest of them alongside it.
+func resolveChecksums(options *Options) error {
+	if len(options.Checksums) == 0 {
+		return nil
+	}
+	for _, spec := range options.Checksums {
+		if strings.TrimSpace(spec.Checksum) == "" {
+			return errors.Errorf("empty %s checksum in Checksums", hashTypeOrDefault(spec.Hash))
+		}
+	}
+	if strings.TrimSpace(options.Checksum) == "" && !options.VerifyStoreChecksumHeader && !options.VerifyContentMD5 {
+		options.Checksum, options.ChecksumHash = options.Checksums[0].Checksum, options.Checksums[0].Hash
+		options.Checksums = options.Checksums[1:]
+	}
+	if len(options.Checksums) > 0 && (options.AppendFrom > 0 || options.ChecksumSeed != nil) {
+		return errors.New("Checksums cannot be combined with AppendFrom or ChecksumSeed")
+	}
+	return nil
+}
+
+// resolveChecksumHash infers ChecksumHash from the length of Checksum if it is unset and
+// Checksum is a hex encoded checksum. Checksums read from files are inferred once read, see
+// createValidator.
+func resolveChecksumHash(options *Options) error {
+	if options.ChecksumHash != 0 || options.Checksum == "" {
+		return nil
+	}
+	if _, err := hex.DecodeString(options.Checksum); err != nil {
+		return nil
+	}
+	hashType, err := inferHashType(options.Checksum)
+	if err != nil {
+		return err
+	}
+	options.ChecksumHash = hashType
+	return nil
+}
+
+// computedChecksum computes the checksum of a download for `Options.ComputeChecksum`.
+type computedChecksum struct {
+	hasher   hash.Hash
+	hashType crypto.Hash
+	// shared is true if hasher is that of the checksum validator, so isn't written to.
+	shared bool
+}
+
+// newComputedChecksum returns the computed checksum for options, sharing the hasher of the
+// checksum validator cv if it uses the same hash, or nil if ComputeChecksum isn't set.
+func newComputedChecksum(cv checksumValidator, options Options) (*computedChecksum, error) {
+	if !options.ComputeChecksum {
+		return nil, nil
+	}
+	if v, ok := cv.(*validator); ok && (options.ChecksumHash == 0 || hashTypeOrDefault(v.hashType) == options.ChecksumHash) {
+		return &computedChecksum{hasher: v.hasher, hashType: hashTypeOrDefault(v.hashType), shared: true}, nil
+	}
+	c := &computedChecksum{hashType: hashTypeOrDefault(options.ChecksumHash)}
+	var err error
+	if options.ChecksumSeed != nil {
+		c.hasher, err = cloneHasher(options.ChecksumSeed, c.hashType)
+	} else {
+		c.hasher, err = newHasher(c.hashType)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compute checksum")
+	}
+	return c, nil
+}
+
+// observers returns the writers to feed the downloaded bytes to, which are none if the
+// hasher is shared.
+func (c *computedChecksum) observers() []io.Writer {
+	if c == nil || c.shared {
+		return nil
+	}
+	return []io.Writer{c.hasher}
+}
+
+// setResult sets the computed checksum in result.
+func (c *computedChecksum) setResult(result *Result) {
+	if c == nil {
+		return
+	}
+	result.Checksum = hex.EncodeToString(c.hasher.Sum(nil))
+	result.ChecksumHash = c.hashType
+}
+
+// hashTypeOrDefault returns hashType, or SHA256 if it is unspecified.
+func hashTypeOrDefault(hashType crypto.Hash) crypto.Hash {
+	if hashType == 0 {
+		return crypto.SHA256
+	}
+	return hashType
+}
+
+// hashValidator is a checksum validator along with the hash it validates.
+type hashValidator struct {
+	checksumValidator
+	hashType crypto.Hash
+}
+
+// multiValidator validates each of its validators over the same bytes, so that the download
+// is only read once however many checksums it is validated against.
+type multiValidator []hashValidator
+
+// newMultiValidator returns a validator for cv, validating ChecksumHash, along with the
+// remaining `Options.Checksums` after resolveChecksums.
+func newMultiValidator(ctx context.Context, cv checksumValidator, httpClient *http.Client, filename string, options Options) (checksumValidator, error) {
+	var m multiValidator
+	if _, skipped := cv.(*noopValidator); !skipped {
+		m = append(m, hashValidator{cv, hashTypeOrDefault(options.ChecksumHash)})
+	}
+	for _, spec := range options.Checksums {
+		v, err := createValidator(ctx, spec.Hash, httpClient, spec.Checksum, filename, options, options.ProgressBars, nil, false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create %s validator", hashTypeOrDefault(spec.Hash))
+		}
+		m = append(m, hashValidator{v, hashTypeOrDefault(spec.Hash)})
+	}
+	return m, nil
+}
+
+func (m multiValidator) Write(p []byte) (int, error) {
+	for _, v := range m {
+		if _, err := v.Write(p); err != nil {
+			return 0, errors.Wrapf(err, "%s checksum", v.hashType)
+		}
+	}
+	return len(p), nil
+}
+
+// validate fails if any of the checksums doesn't match, naming the hash that failed.
+func (m multiValidator) validate() error {
+	for _, v := range m {
+		if err := v.validate(); err != nil {
+			return errors.Wrapf(err, "%s checksum", v.hashType)
+		}
+	}
+	return nil
+}
+
+var _ checksumValidator = multiValidator{}
@@ -0,0 +1,101 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"crypto"
+	"encoding/hex"
+	"hash"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrChunkMismatch is returned (wrapped) when a chunk of a download does not match its
+// checksum in `Options.ChunkChecksums`.
+var ErrChunkMismatch = errors.New("chunk checksum mismatch")
+
+var _ checksumValidator = &chunkValidator{}
+
+// chunkValidator validates each fixed size chunk written to it as soon as it is complete.
+type chunkValidator struct {
+	hasher    hash.Hash
+	size      int64
+	checksums []string
+
+	chunk   int
+	written int64
+}
+
+func newChunkValidator(hashType crypto.Hash, size int64, checksums []string) (*chunkValidator, error) {
+	if size <= 0 {
+		return nil, errors.New("ChunkSize must be positive when ChunkChecksums is set")
+	}
+	hasher, err := newHasher(hashType)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkValidator{
+		hasher:    hasher,
+		size:      size,
+		checksums: checksums,
+	}, nil
+}
+
+func (v *chunkValidator) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if v.chunk >= len(v.checksums) {
+			return n, errors.Wrapf(ErrChunkMismatch, "downloaded more than %d chunks", len(v.checksums))
+		}
+		l := int64(len(p))
+		if remaining := v.size - v.written; l > remaining {
+			l = remaining
+		}
+		_, _ = v.hasher.Write(p[:l]) // #nosec
+		v.written += l
+		n += int(l)
+		p = p[l:]
+		if v.written == v.size {
+			if err := v.checkChunk(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// checkChunk validates the current chunk and moves on to the next.
+func (v *chunkValidator) checkChunk() error {
+	if hex.EncodeToString(v.hasher.Sum(nil)) != strings.ToLower(v.checksums[v.chunk]) {
+		return errors.Wrapf(ErrChunkMismatch, "chunk %d", v.chunk)
+	}
+	v.hasher.Reset()
+	v.chunk++
+	v.written = 0
+	return nil
+}
+
+func (v *chunkValidator) validate() error {
+	if v.written > 0 {
+		if err := v.checkChunk(); err != nil {
+			return err
+		}
+	}
+	if v.chunk != len(v.checksums) {
+		return errors.Wrapf(ErrChunkMismatch, "downloaded %d chunks, expected %d", v.chunk, len(v.checksums))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock whose time only passes when waited on, and then instantly.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestRetryAfterClock(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &fakeClock{now: start}
+	attempts := 0
+	err := retryAfter(context.Background(), 3, func() error {
+		attempts++
+		return &retriableError{errors.New("temporary")}
+	}, time.Hour, c)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if attempts != 3 {
+		t.Fatalf("unexpected attempts, expected: %d, actual: %d", 3, attempts)
+	}
+	if elapsed := c.Now().Sub(start); elapsed != 3*time.Hour {
+		t.Fatalf("unexpected elapsed time, expected: %v, actual: %v", 3*time.Hour, elapsed)
+	}
+}
+
+func TestSignedURLClock(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &fakeClock{now: start}
+	u, _ := url.Parse("https://example.com/file")
+	s := newSignedURL(u, Options{URLExpiry: start.Add(time.Minute), clock: c})
+	if _, err := s.next(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-c.After(time.Minute)
+	if _, err := s.next(); !errors.Is(err, ErrURLExpired) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", ErrURLExpired, err)
+	}
+}
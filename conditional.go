@@ -0,0 +1,158 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotModified is returned (wrapped) when a conditional request, see
+// `Options.IfNoneMatch` and `Options.IfModifiedSince`, receives a 304 Not Modified response.
+var ErrNotModified = errors.New("not modified")
+
+// conditionalRequest holds the validators of the existing destination file, sent to make
+// the request conditional.
+type conditionalRequest struct {
+	ifModifiedSince time.Time
+	ifNoneMatch     string
+}
+
+// resolveConditional makes requests conditional on `Options.IfNoneMatch` and
+// `Options.IfModifiedSince`, unless they are already conditional.
+func resolveConditional(options *Options) {
+	if options.conditional != nil || (options.IfNoneMatch == "" && options.IfModifiedSince.IsZero()) {
+		return
+	}
+	options.conditional = &conditionalRequest{ifModifiedSince: options.IfModifiedSince, ifNoneMatch: options.IfNoneMatch}
+}
+
+func (c *conditionalRequest) setHeaders(req *http.Request) {
+	if c == nil {
+		return
+	}
+	if !c.ifModifiedSince.IsZero() {
+		req.Header.Set("If-Modified-Since", c.ifModifiedSince.UTC().Format(http.TimeFormat))
+	}
+	if c.ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", c.ifNoneMatch)
+	}
+}
+
+// etagFileName returns the path of the ETag sidecar for dest, or "" if disabled.
+func etagFileName(dest string, options FileOptions) string {
+	if options.DisableETagFile {
+		return ""
+	}
+	if options.ETagFile != "" {
+		return options.ETagFile
+	}
+	return dest + ".etag"
+}
+
+func readETagFile(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "failed to read ETag file")
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// writeETagFile stores etag in name, removing any stale ETag if the server didn't send one.
+func writeETagFile(name, etag string) error {
+	if name == "" {
+		return nil
+	}
+	if etag == "" {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to remove ETag file")
+		}
+		return nil
+	}
+	if err := ioutil.WriteFile(name, []byte(etag+"\n"), 0600); err != nil {
+		return errors.Wrap(err, "failed to write ETag file")
+	}
+	return nil
+}
+
+// DownloadIfChanged downloads the specified `src` URL to `dest` file using the specified
+// `FileOptions` only if it has changed since `dest` was last downloaded, as determined by
+// the server from the modification time of `dest` and its ETag. The modification time of
+// `dest` is set to the Last-Modified time of the response after each download, and the ETag
+// is stored in a sidecar file (see `FileOptions.ETagFile`). `changed` reports whether `dest`
+// was downloaded, so it is false if the download was skipped by `FileOptions.IfExists`.
+func DownloadIfChanged(src, dest string, options FileOptions) (changed bool, err error) {
+	wrap := takeWrapError(&options.Options)
+	changed, err = downloadIfChanged(context.Background(), src, dest, options)
+	return changed, wrapError(wrap, "DownloadIfChanged", err)
+}
+
+func downloadIfChanged(ctx context.Context, src, dest string, options FileOptions) (bool, error) {
+	if options.DirectWrite {
+		// dest would be truncated before the server is asked whether it has changed.
+		return false, errors.New("DownloadIfChanged cannot be used with DirectWrite")
+	}
+	if options.DestTemplate {
+		// dest isn't known until the server has responded.
+		return false, errors.New("DownloadIfChanged cannot be used with DestTemplate")
+	}
+	etagFile := etagFileName(dest, options)
+	if fi, err := os.Stat(dest); err == nil {
+		etag, err := readETagFile(etagFile)
+		if err != nil {
+			return false, err
+		}
+		options.conditional = &conditionalRequest{ifModifiedSince: fi.ModTime(), ifNoneMatch: etag}
+	} else if !os.IsNotExist(err) {
+		return false, errors.Wrap(err, "failed to check destination file")
+	}
+
+	result, err := toFile(ctx, src, dest, options)
+	if err != nil {
+		if errors.Is(err, ErrNotModified) {
+			return false, nil
+		}
+		return false, err
+	}
+	if result.DryRun != nil {
+		return result.DryRun.WouldDownload, nil
+	}
+	if result.Skipped {
+		// dest was left alone by IfExists, so its ETag file still describes it.
+		return false, nil
+	}
+
+	if !result.LastModified.IsZero() {
+		if err = os.Chtimes(dest, time.Now(), result.LastModified); err != nil {
+			return true, errors.Wrap(err, "failed to set modification time of destination file")
+		}
+	}
+	if err = writeETagFile(etagFile, result.ETag); err != nil {
+		return true, err
+	}
+	return true, nil
+}
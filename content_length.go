@@ -0,0 +1,51 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// checkContentLength returns an error wrapping ErrSizeMismatch if read, the number of bytes
+// read from the body of resp, differs from its positive Content-Length, unless disabled by
+// `Options.VerifyContentLength`.
+func checkContentLength(resp *http.Response, read int64, options Options) error {
+	if options.VerifyContentLength != nil && !*options.VerifyContentLength {
+		return nil
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// The body isn't read, as there is nothing to append.
+		return nil
+	}
+	if resp.ContentLength > 0 && read != resp.ContentLength {
+		return errors.Wrapf(ErrSizeMismatch, "received %d bytes, expected Content-Length of %d bytes", read, resp.ContentLength)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"crypto"
+	"crypto/md5" // #nosec
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// resolveContentMD5 checks `Options.VerifyContentMD5` and sets ChecksumHash to MD5, so that
+// the download is hashed with MD5 from the start.
+func resolveContentMD5(options *Options) error {
+	if !options.VerifyContentMD5 {
+		return nil
+	}
+	if options.Checksum != "" || options.VerifyStoreChecksumHeader || options.VerifyFromFilename {
+		return errors.New("VerifyContentMD5 cannot be combined with Checksum, VerifyStoreChecksumHeader or VerifyFromFilename")
+	}
+	if options.ChecksumHash != 0 && options.ChecksumHash != crypto.MD5 {
+		return errors.New("VerifyContentMD5 requires ChecksumHash to be MD5")
+	}
+	options.ChecksumHash = crypto.MD5
+	return nil
+}
+
+// contentMD5 returns the hex encoded checksum in the Content-MD5 header of resp. If the header
+// is absent, an error is returned unless `Options.ContentMD5Optional` is set, in which case a
+// warning is logged and an empty checksum is returned.
+func contentMD5(resp *http.Response, options Options) (string, error) {
+	value := strings.TrimSpace(resp.Header.Get("Content-MD5"))
+	if value == "" {
+		if !options.ContentMD5Optional {
+			return "", errors.New("missing Content-MD5 header")
+		}
+		if options.Logger != nil {
+			options.Logger.Printf("warning: no Content-MD5 header in response from %s, download not validated", resp.Request.URL)
+		}
+		return "", nil
+	}
+	digest, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(digest) != md5.Size {
+		return "", checksumFormatError("invalid Content-MD5 header: %s", value)
+	}
+	return hex.EncodeToString(digest), nil
+}
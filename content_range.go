@@ -0,0 +1,103 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// errContentRangeMismatch is returned (wrapped) when a partial response does not start at
+// the requested offset. Appending such a response would misalign the data.
+var errContentRangeMismatch = errors.New("content range mismatch")
+
+// errRangeIgnored is returned when the server responds to the Range request resuming a partial
+// download with the whole resource, or with a range that doesn't continue the partial
+// download, so that the download is restarted.
+var errRangeIgnored = errors.New("range request ignored")
+
+// checkContentRange verifies that resp is a 206 Partial Content response whose Content-Range
+// starts at offset, the size of the local partial download being resumed.
+func checkContentRange(resp *http.Response, offset int64) error {
+	if resp.StatusCode != http.StatusPartialContent {
+		return errors.Wrapf(errContentRangeMismatch, "received status code %d (expected %d)", resp.StatusCode, http.StatusPartialContent)
+	}
+	start, err := parseContentRangeStart(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return errors.Wrap(errContentRangeMismatch, err.Error())
+	}
+	if start != offset {
+		return errors.Wrapf(errContentRangeMismatch, "response starts at byte %d (expected %d)", start, offset)
+	}
+	return nil
+}
+
+// parseContentRangeStart returns the first byte position of a Content-Range header value of
+// the form `bytes START-END/SIZE`.
+func parseContentRangeStart(contentRange string) (int64, error) {
+	const unit = "bytes "
+	if !strings.HasPrefix(contentRange, unit) {
+		return 0, errors.Errorf("invalid Content-Range: %q", contentRange)
+	}
+	byteRange := strings.TrimPrefix(contentRange, unit)
+	dash := strings.Index(byteRange, "-")
+	if dash <= 0 {
+		return 0, errors.Errorf("invalid Content-Range: %q", contentRange)
+	}
+	start, err := strconv.ParseInt(byteRange[:dash], 10, 64)
+	if err != nil || start < 0 {
+		return 0, errors.Errorf("invalid Content-Range: %q", contentRange)
+	}
+	return start, nil
+}
+
+// appendProgress returns the total size of a download resumed at offset and the number of bytes
+// of it already downloaded, given the response to the Range request for the rest of it and its
+// size, so that progress is reported over the whole download.
+func appendProgress(resp *http.Response, size, offset int64) (total, current int64) {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return offset + size, offset
+	case http.StatusRequestedRangeNotSatisfiable:
+		return offset, offset
+	}
+	// The Range request was ignored, so the response is the whole download.
+	return size, offset
+}
+
+// appendReader returns the bytes of resp from offset onwards, read from its body, the
+// response to a Range request for them.
+func appendReader(resp *http.Response, body io.Reader, offset int64) (io.Reader, error) {
+	switch resp.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+		// There are no bytes beyond offset.
+		return bytes.NewReader(nil), nil
+	case http.StatusOK:
+		// The Range request was ignored, so skip the bytes before offset.
+		if _, err := io.CopyN(ioutil.Discard, body, offset); err != nil {
+			if err == io.EOF {
+				return nil, errors.Errorf("download is smaller than AppendFrom offset %d", offset)
+			}
+			return nil, errors.Wrap(err, "failed to skip to AppendFrom offset")
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,84 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestCheckContentRange(t *testing.T) {
+	for _, tc := range []struct {
+		status       int
+		contentRange string
+		offset       int64
+		ok           bool
+	}{
+		{http.StatusPartialContent, "bytes 3-5/6", 3, true},
+		{http.StatusPartialContent, "bytes 0-5/6", 3, false},
+		{http.StatusPartialContent, "bytes 4-5/*", 3, false},
+		{http.StatusPartialContent, "", 3, false},
+		{http.StatusPartialContent, "bytes */6", 3, false},
+		{http.StatusOK, "", 3, false},
+	} {
+		resp := &http.Response{StatusCode: tc.status, Header: http.Header{}}
+		if tc.contentRange != "" {
+			resp.Header.Set("Content-Range", tc.contentRange)
+		}
+		err := checkContentRange(resp, tc.offset)
+		if tc.ok {
+			if err != nil {
+				t.Fatalf("%d %q: unexpected error: %v", tc.status, tc.contentRange, err)
+			}
+			continue
+		}
+		if !errors.Is(err, errContentRangeMismatch) {
+			t.Fatalf("%d %q: unexpected error, expected: '%v', actual: '%v'", tc.status, tc.contentRange, errContentRangeMismatch, err)
+		}
+	}
+}
+
+func TestAppendProgress(t *testing.T) {
+	for _, tc := range []struct {
+		status          int
+		size            int64
+		total, progress int64
+	}{
+		{http.StatusPartialContent, 3, 6, 3},
+		{http.StatusOK, 6, 6, 3},
+		{http.StatusRequestedRangeNotSatisfiable, 0, 3, 3},
+	} {
+		total, current := appendProgress(&http.Response{StatusCode: tc.status}, tc.size, 3)
+		if total != tc.total || current != tc.progress {
+			t.Fatalf("%d: wrong progress, expected %d / %d, actual %d / %d", tc.status, tc.progress, tc.total, current, total)
+		}
+	}
+}
+
+func TestRetryBarStartAtOffset(t *testing.T) {
+	var r retryBar
+	options := &ProgressBarOptions{Writer: ioutil.Discard}
+	bar := r.start(6, 3, options)
+	defer r.finish()
+	if bar.Get() != 3 || bar.Total != 6 {
+		t.Fatalf("wrong initial progress, expected 3 / 6, actual %d / %d", bar.Get(), bar.Total)
+	}
+	bar = r.start(10, 4, options)
+	if bar.Get() != 4 || bar.Total != 10 {
+		t.Fatalf("wrong restarted progress, expected 4 / 10, actual %d / %d", bar.Get(), bar.Total)
+	}
+}
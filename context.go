@@ -0,0 +1,45 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"io"
+)
+
+// withTimeout returns ctx with the deadline of `Options.Timeout`, which is cleared so that it
+// is only applied once to the whole download, however many requests and retries it takes.
+func withTimeout(ctx context.Context, options *Options) (context.Context, context.CancelFunc) {
+	if options.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	timeout := options.Timeout
+	options.Timeout = 0
+	return context.WithTimeout(ctx, timeout)
+}
+
+// contextReader stops reading from r as soon as ctx is done, so that a cancelled download
+// doesn't carry on copying body bytes that have already been received.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
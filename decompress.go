@@ -0,0 +1,188 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// Decompression is the decompression to apply to a downloaded file.
+type Decompression int
+
+const (
+	// DecompressNone writes the downloaded bytes as-is.
+	DecompressNone Decompression = iota
+	// DecompressGzip decompresses a gzip compressed download.
+	DecompressGzip
+	// DecompressBzip2 decompresses a bzip2 compressed download.
+	DecompressBzip2
+	// DecompressXz decompresses an xz compressed download.
+	DecompressXz
+	// DecompressBrotli decompresses a brotli compressed download.
+	DecompressBrotli
+	// DecompressAuto detects the compression from the response Content-Type, falling back
+	// to the extension of the source URL, and confirms it by sniffing the magic bytes at the
+	// start of the download. Downloads that are not recognized are written as-is.
+	DecompressAuto
+	// DecompressDeflate decompresses a deflate compressed download, either zlib wrapped as
+	// specified for the deflate Content-Encoding or raw as sent by some servers.
+	DecompressDeflate
+)
+
+// acceptEncoding is the Accept-Encoding header sent when decoding Content-Encodings.
+const acceptEncoding = "gzip, deflate, br"
+
+var (
+	decompressionExtensions = map[string]Decompression{
+		".gz":   DecompressGzip,
+		".tgz":  DecompressGzip,
+		".bz2":  DecompressBzip2,
+		".tbz2": DecompressBzip2,
+		".xz":   DecompressXz,
+		".txz":  DecompressXz,
+		".br":   DecompressBrotli,
+	}
+	decompressionContentTypes = map[string]Decompression{
+		"application/gzip":    DecompressGzip,
+		"application/x-gzip":  DecompressGzip,
+		"application/x-bzip2": DecompressBzip2,
+		"application/x-xz":    DecompressXz,
+	}
+	// decompressionContentEncodings are the Content-Encodings decoded by
+	// Options.DecodeContentEncoding.
+	decompressionContentEncodings = map[string]Decompression{
+		"gzip":    DecompressGzip,
+		"x-gzip":  DecompressGzip,
+		"deflate": DecompressDeflate,
+		"br":      DecompressBrotli,
+	}
+	// decompressionMagic holds the magic bytes used to sniff each decompression. Brotli
+	// streams have no magic bytes, so are never sniffed.
+	decompressionMagic = map[Decompression][]byte{
+		DecompressGzip:  {0x1f, 0x8b},
+		DecompressBzip2: []byte("BZh"),
+		DecompressXz:    {0xfd, '7', 'z', 'X', 'Z', 0x00},
+	}
+)
+
+// detectDecompression resolves DecompressAuto to the decompression to use for resp.
+func detectDecompression(d Decompression, src *url.URL, resp *http.Response) Decompression {
+	if d != DecompressAuto {
+		return d
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		if detected, ok := decompressionContentTypes[mediaType]; ok {
+			return detected
+		}
+	}
+	if detected, ok := decompressionExtensions[strings.ToLower(path.Ext(src.Path))]; ok {
+		return detected
+	}
+	return DecompressNone
+}
+
+// contentEncodingDecompression returns the decompression needed to decode the
+// Content-Encoding of resp.
+func contentEncodingDecompression(resp *http.Response) (Decompression, error) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return DecompressNone, nil
+	}
+	if d, ok := decompressionContentEncodings[encoding]; ok {
+		return d, nil
+	}
+	return DecompressNone, errors.Errorf("unsupported Content-Encoding: %s", encoding)
+}
+
+// newDecompressionReader wraps r to decompress it with d. If sniff is true, the magic bytes
+// at the start of r are checked first and r is returned undecompressed if they don't match.
+// The decompression actually applied is returned along with the reader.
+func newDecompressionReader(d Decompression, r io.Reader, sniff bool) (io.Reader, Decompression, error) {
+	if magic, ok := decompressionMagic[d]; sniff && ok {
+		br := bufio.NewReader(r)
+		if b, _ := br.Peek(len(magic)); !bytes.Equal(b, magic) {
+			return br, DecompressNone, nil
+		}
+		r = br
+	}
+
+	switch d {
+	case DecompressNone:
+		return r, d, nil
+	case DecompressGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, d, errors.Wrap(err, "failed to create gzip reader")
+		}
+		return gr, d, nil
+	case DecompressBzip2:
+		return bzip2.NewReader(r), d, nil
+	case DecompressXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, d, errors.Wrap(err, "failed to create xz reader")
+		}
+		return xr, d, nil
+	case DecompressBrotli:
+		return brotli.NewReader(r), d, nil
+	case DecompressDeflate:
+		br := bufio.NewReader(r)
+		if b, _ := br.Peek(2); !isZlibHeader(b) {
+			return flate.NewReader(br), d, nil
+		}
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			return nil, d, errors.Wrap(err, "failed to create zlib reader")
+		}
+		return zr, d, nil
+	default:
+		return nil, d, errors.New("invalid decompression")
+	}
+}
+
+// isZlibHeader returns true if b starts with a zlib header using the deflate method.
+func isZlibHeader(b []byte) bool {
+	return len(b) == 2 && b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// decompressedName returns the name of the file name decompressed with d, e.g. `foo` for
+// `foo.gz` or `foo.tar` for `foo.tgz`. If name doesn't have an extension matching d, it is
+// returned unchanged.
+func decompressedName(name string, d Decompression) string {
+	ext := path.Ext(name)
+	if detected, ok := decompressionExtensions[strings.ToLower(ext)]; !ok || detected != d {
+		return name
+	}
+	name = strings.TrimSuffix(name, ext)
+	if strings.HasPrefix(strings.ToLower(ext), ".t") {
+		name += ".tar"
+	}
+	return name
+}
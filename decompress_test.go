@@ -0,0 +1,330 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	download "github.com/jimmidyson/go-download"
+)
+
+type decompression struct {
+	file       string
+	decompress download.Decompression
+}
+
+var decompressionTests = []decompression{
+	{"testfile.gz", download.DecompressGzip},
+	{"testfile.gz", download.DecompressAuto},
+	{"testfile.bz2", download.DecompressBzip2},
+	{"testfile.bz2", download.DecompressAuto},
+	{"testfile.xz", download.DecompressXz},
+	{"testfile.xz", download.DecompressAuto},
+	{"testfile", download.DecompressAuto},
+}
+
+func TestDownloadToFileDecompress(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, d := range decompressionTests {
+		tmpFile := filepath.Join(targetDir, "testfile")
+		err = download.ToFile(srv.URL+"/"+d.file, tmpFile, download.FileOptions{Decompress: d.decompress})
+		if err != nil {
+			t.Fatalf("unexpected error downloading %s: %v", d.file, err)
+		}
+
+		downloadedData, err := ioutil.ReadFile(tmpFile)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(testData, downloadedData) {
+			t.Fatalf("wrong downloaded data for %s", d.file)
+		}
+	}
+}
+
+func TestDownloadToFileDecompressChecksum(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+
+	err = download.ToFile(srv.URL+"/testfile.gz", tmpFile, download.FileOptions{
+		Options: download.Options{
+			Checksum: "a3df43ba11c7f4216953d5acaa3be48f15f0ab3fc874335c45c7db7c9c7ef0ae",
+		},
+		Decompress: download.DecompressGzip,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = download.ToFile(srv.URL+"/testfile.gz", tmpFile, download.FileOptions{
+		Options: download.Options{
+			Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+		},
+		Decompress:             download.DecompressGzip,
+		ChecksumOfDecompressed: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = download.ToFile(srv.URL+"/testfile.gz", tmpFile, download.FileOptions{
+		Options: download.Options{
+			Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+		},
+		Decompress: download.DecompressGzip,
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDownloadToFileDecompressAutoSniff(t *testing.T) {
+	gzData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile.gz"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hf := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		switch req.URL.Path {
+		case "/undeclared.gz":
+			_, _ = w.Write(gzData)
+		case "/notreally.gz":
+			_, _ = w.Write(testData)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+	srv := httptest.NewServer(hf)
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err = os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	for _, file := range []string{"undeclared.gz", "notreally.gz"} {
+		tmpFile := filepath.Join(targetDir, "testfile")
+		err = download.ToFile(srv.URL+"/"+file, tmpFile, download.FileOptions{Decompress: download.DecompressAuto})
+		if err != nil {
+			t.Fatalf("unexpected error downloading %s: %v", file, err)
+		}
+
+		downloadedData, err := ioutil.ReadFile(tmpFile)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(testData, downloadedData) {
+			t.Fatalf("wrong downloaded data for %s", file)
+		}
+	}
+}
+
+func TestDownloadToFileDecompressChecksumFile(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+
+	for _, tc := range []struct {
+		checksumFile           string
+		decompress             download.Decompression
+		checksumOfDecompressed bool
+		success                bool
+	}{
+		{"testfile.gz.sha256", download.DecompressGzip, false, true},
+		{"testfile.gz.sha256", download.DecompressAuto, false, true},
+		{"testfile.gz.sha256", download.DecompressGzip, true, false},
+		{"CHECKSUMS.sha256", download.DecompressGzip, true, true},
+		{"CHECKSUMS.sha256", download.DecompressAuto, true, true},
+		{"CHECKSUMS.sha256", download.DecompressGzip, false, false},
+	} {
+		err = download.ToFile(srv.URL+"/testfile.gz", tmpFile, download.FileOptions{
+			Options: download.Options{
+				Checksum: srv.URL + "/" + tc.checksumFile,
+			},
+			Decompress:             tc.decompress,
+			ChecksumOfDecompressed: tc.checksumOfDecompressed,
+		})
+		if tc.success && err != nil {
+			t.Fatalf("unexpected error validating against %s (checksum of decompressed: %v): %v", tc.checksumFile, tc.checksumOfDecompressed, err)
+		}
+		if !tc.success && err == nil {
+			t.Fatalf("expected error validating against %s (checksum of decompressed: %v)", tc.checksumFile, tc.checksumOfDecompressed)
+		}
+	}
+}
+
+func TestDownloadToFileDecodeBrotliContentEncoding(t *testing.T) {
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var brData bytes.Buffer
+	bw := brotli.NewWriter(&brData)
+	if _, err = bw.Write(testData); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = bw.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hf := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.Contains(req.Header.Get("Accept-Encoding"), "br") {
+			_, _ = w.Write(testData)
+			return
+		}
+		w.Header().Set("Content-Encoding", "br")
+		_, _ = w.Write(brData.Bytes())
+	})
+	srv := httptest.NewServer(hf)
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err = os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	for _, tc := range []struct {
+		checksumOfDecompressed bool
+		success                bool
+	}{
+		{true, true},
+		{false, false},
+	} {
+		err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+			Options: download.Options{
+				Checksum:              "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+				DecodeContentEncoding: true,
+			},
+			ChecksumOfDecompressed: tc.checksumOfDecompressed,
+		})
+		if !tc.success {
+			if err == nil {
+				t.Fatalf("checksumOfDecompressed %t: expected error", tc.checksumOfDecompressed)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("checksumOfDecompressed %t: unexpected error: %v", tc.checksumOfDecompressed, err)
+		}
+
+		downloadedData, err := ioutil.ReadFile(tmpFile)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(testData, downloadedData) {
+			t.Fatal("wrong downloaded data")
+		}
+	}
+}
+
+func TestDownloadToWriterDecodeDeflateContentEncoding(t *testing.T) {
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var zlibData, rawData bytes.Buffer
+	zw := zlib.NewWriter(&zlibData)
+	fw, err := flate.NewWriter(&rawData, flate.DefaultCompression)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, w := range []io.WriteCloser{zw, fw} {
+		if _, err = w.Write(testData); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.Contains(req.Header.Get("Accept-Encoding"), "deflate") {
+			_, _ = w.Write(testData)
+			return
+		}
+		w.Header().Set("Content-Encoding", "deflate")
+		if req.URL.Path == "/raw" {
+			_, _ = w.Write(rawData.Bytes())
+			return
+		}
+		_, _ = w.Write(zlibData.Bytes())
+	}))
+	defer srv.Close()
+
+	for _, path := range []string{"/zlib", "/raw"} {
+		var buf bytes.Buffer
+		err = download.ToWriter(srv.URL+path, &buf, download.Options{DecodeContentEncoding: true})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", path, err)
+		}
+		if !bytes.Equal(testData, buf.Bytes()) {
+			t.Fatalf("%s: wrong downloaded data: '%s'", path, buf.Bytes())
+		}
+	}
+}
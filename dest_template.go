@@ -0,0 +1,73 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// destPlaceholderPattern matches the placeholders supported by `FileOptions.DestTemplate`.
+var destPlaceholderPattern = regexp.MustCompile(`\{(filename|etag|final-host)\}`)
+
+// destTemplateDir returns the directory of the longest leading part of the dest template
+// that contains no placeholders, which the download is written to before it is moved to
+// the resolved dest.
+func destTemplateDir(dest string) string {
+	if loc := destPlaceholderPattern.FindStringIndex(dest); loc != nil {
+		return filepath.Dir(dest[:loc[0]] + "x")
+	}
+	return filepath.Dir(dest)
+}
+
+// resolveDestTemplate replaces the placeholders in the dest template with values from resp.
+func resolveDestTemplate(dest string, resp *http.Response) (string, error) {
+	var err error
+	resolved := destPlaceholderPattern.ReplaceAllStringFunc(dest, func(placeholder string) string {
+		var value string
+		switch placeholder {
+		case "{filename}":
+			value = responseFilename(resp)
+		case "{etag}":
+			value = strings.Trim(strings.TrimPrefix(resp.Header.Get("ETag"), "W/"), `"`)
+		case "{final-host}":
+			value = resp.Request.URL.Hostname()
+		}
+		if value == "" || value == "." || value == ".." || strings.ContainsAny(value, `/\`) {
+			if err == nil {
+				err = errors.Errorf("failed to resolve destination template %s: invalid value %q for %s", dest, value, placeholder)
+			}
+		}
+		return value
+	})
+	return resolved, err
+}
+
+// responseFilename returns the filename from the Content-Disposition header of resp, falling
+// back to the last element of the final URL path.
+func responseFilename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if filename := params["filename"]; filename != "" {
+			return path.Base(filepath.ToSlash(filename))
+		}
+	}
+	return path.Base(resp.Request.URL.Path)
+}
@@ -0,0 +1,89 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"crypto"
+	"encoding/hex"
+	"fmt"
+	"hash"
+
+	"github.com/pkg/errors"
+)
+
+// ErrChecksumMismatch is returned (wrapped) when the downloaded content doesn't match the
+// configured checksum. The error includes the expected and computed digests, which can be
+// retrieved with errors.As as a *ChecksumMismatchError.
+var ErrChecksumMismatch = errors.New("checksum validation failed")
+
+// ChecksumMismatchError is the error wrapped when the downloaded content doesn't match the
+// configured checksum. It matches ErrChecksumMismatch with errors.Is.
+type ChecksumMismatchError struct {
+	// Expected holds the expected checksums, any of which would have matched.
+	Expected []string
+	// Actual is the computed checksum.
+	Actual string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	if len(e.Expected) == 1 {
+		return fmt.Sprintf("expected %s, computed %s: %v", e.Expected[0], e.Actual, ErrChecksumMismatch)
+	}
+	return fmt.Sprintf("expected one of %v, computed %s: %v", e.Expected, e.Actual, ErrChecksumMismatch)
+}
+
+func (e *ChecksumMismatchError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// checksumDiagnostics counts the bytes passed to the checksum validator and, if a
+// `Options.DiagnosticHash` is configured, computes a secondary digest of them so that
+// checksum mismatches can be reported with enough detail to triage corruption.
+type checksumDiagnostics struct {
+	hashType crypto.Hash
+	hasher   hash.Hash
+	written  int64
+}
+
+func newChecksumDiagnostics(hashType crypto.Hash) (*checksumDiagnostics, error) {
+	d := &checksumDiagnostics{hashType: hashType}
+	if hashType != 0 {
+		hasher, err := newHasher(hashType)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid diagnostic hash")
+		}
+		d.hasher = hasher
+	}
+	return d, nil
+}
+
+func (d *checksumDiagnostics) Write(p []byte) (int, error) {
+	d.written += int64(len(p))
+	if d.hasher != nil {
+		_, _ = d.hasher.Write(p) // #nosec
+	}
+	return len(p), nil
+}
+
+// annotate adds the byte count and diagnostic digest to err if it is a checksum mismatch.
+func (d *checksumDiagnostics) annotate(err error) error {
+	if !errors.Is(err, ErrChecksumMismatch) {
+		return err
+	}
+	if d.hasher == nil {
+		return errors.Wrapf(err, "read %d bytes", d.written)
+	}
+	return errors.Wrapf(err, "read %d bytes with %s %s", d.written, d.hashType, hex.EncodeToString(d.hasher.Sum(nil)))
+}
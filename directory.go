@@ -0,0 +1,42 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrIsDirectory is returned (wrapped) by ToFile when the URL is for a directory, such as
+// `https://example.com/releases/`, and the server responds with an HTML directory listing
+// rather than a file.
+var ErrIsDirectory = errors.New("URL is a directory")
+
+// checkNotDirectory returns an error wrapping ErrIsDirectory if resp is an HTML page served
+// for a directory-style URL, i.e. one whose path is empty or ends with a slash.
+func checkNotDirectory(resp *http.Response) error {
+	u := resp.Request.URL
+	if u.Path != "" && !strings.HasSuffix(u.Path, "/") {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/html" {
+		return nil
+	}
+	return errors.Wrapf(ErrIsDirectory, "%s is a directory listing, not a file", u)
+}
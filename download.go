@@ -15,11 +15,13 @@
 package download
 
 import (
+	"context"
 	"crypto"
-	"crypto/md5" // #nosec
-	"crypto/sha1"
+	// Register the hash functions supported out of the box for newHasher.
+	_ "crypto/md5" // #nosec
+	_ "crypto/sha1"
 	"crypto/sha256"
-	"crypto/sha512"
+	_ "crypto/sha512"
 	"fmt"
 	"hash"
 	"io"
@@ -29,13 +31,85 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
+	_ "golang.org/x/crypto/blake2b"
+	_ "golang.org/x/crypto/sha3"
 	pb "gopkg.in/cheggaaa/pb.v1"
 )
 
-// Options holds the possible configuration options for the Downloader.
+// ErrShortDownload is returned when the connection is closed before the full response body
+// has been received, e.g. a chunked transfer that is cut off mid-chunk. It is treated as a
+// retriable error when downloading to a file.
+var ErrShortDownload = errors.New("short download")
+
+// ErrEmptyResponse is returned (wrapped) when a response has an empty body but
+// `Options.MinBytes` requires content, e.g. a misbehaving mirror serving an empty 200 response.
+// It is treated as a retriable error when downloading to a file.
+var ErrEmptyResponse = errors.New("empty response")
+
+// StatusError is returned (wrapped) when the server responds with an unexpected status code,
+// e.g. a 404 Not Found, and can be retrieved with errors.As.
+type StatusError struct {
+	// StatusCode is the status code of the response.
+	StatusCode int
+	// URL is the URL of the request, after following redirects.
+	URL *url.URL
+	// expected describes the status codes that were expected.
+	expected string
+}
+
+func newStatusError(resp *http.Response, expected string) *StatusError {
+	e := &StatusError{StatusCode: resp.StatusCode, expected: expected}
+	if resp.Request != nil {
+		e.URL = resp.Request.URL
+	}
+	return e
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("received invalid status code: %d (%s)", e.StatusCode, e.expected)
+}
+
+// ErrDestinationNotWritable is returned (wrapped) when the destination file or its directory
+// cannot be created, e.g. due to insufficient permissions or a non-existent path.
+var ErrDestinationNotWritable = errors.New("destination is not writable")
+
+// destinationError marks an error as being caused by an unwritable destination, while
+// keeping the underlying cause.
+type destinationError struct {
+	err error
+}
+
+func (e *destinationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *destinationError) Unwrap() error {
+	return e.err
+}
+
+func (e *destinationError) Is(target error) bool {
+	return target == ErrDestinationNotWritable
+}
+
+// wrapDestinationError wraps err with message, marking it with ErrDestinationNotWritable if
+// it was caused by permissions or a missing or read-only path.
+func wrapDestinationError(err error, message string) error {
+	wrapped := errors.Wrap(err, message)
+	if os.IsPermission(err) || os.IsNotExist(err) || errors.Is(err, syscall.EROFS) || errors.Is(err, syscall.ENOTDIR) {
+		return &destinationError{wrapped}
+	}
+	return wrapped
+}
+
+// Options holds the possible configuration options for the Downloader. Options are never
+// modified by downloads, so the same Options (including its HTTPClient) may be shared by
+// concurrent downloads.
 type Options struct {
 	// HTTPClient is an optional client to perform downloads with. If nil, `http.DefaultClient`
 	// will be used.
@@ -43,17 +117,267 @@ type Options struct {
 	// Checksum is either a checksum string, or a URL or path to a file containing the checksum. The file
 	// can either contain the checksum only or contain multiple lines of the format:
 	// CHECKSUM FILENAME
+	// It can also be a Subresource Integrity string such as `sha384-BASE64DIGEST`, listing one or
+	// more space separated alternatives, any of which may match. The hash function is taken from
+	// each alternative rather than ChecksumHash.
 	Checksum string
-	// Checksum hash is the hash for the checksum. Currently only supports SHA1, SHA256, SHA512 and MD5.
-	// If unspecified, defaults to SHA256.
+	// AcceptableChecksums lists hex encoded checksums of type ChecksumHash, any of which the
+	// download may match, e.g. while an artifact is being rolled out to a new version. Cannot
+	// be combined with Checksum.
+	AcceptableChecksums []string
+	// Checksum hash is the hash for the checksum. Any hash whose package is imported, so that
+	// it is available, is supported; MD5, SHA1, SHA256, SHA384, SHA512, SHA3 and BLAKE2b
+	// always are. If unspecified, it is inferred from the length of the hex encoded checksum
+	// (MD5, SHA1, SHA256 or SHA512), failing if the length is of none of them, and defaults
+	// to SHA256 for other checksums.
 	ChecksumHash crypto.Hash
+	// Checksums lists further checksums, each with its own hash, that the download must also
+	// match, e.g. for artifacts publishing both an MD5 and a SHA256 checksum. They are all
+	// computed in a single pass over the download, which fails naming the hash of the first
+	// checksum that doesn't match. If Checksum is unset, the first of them is used in its
+	// place. Cannot be combined with AppendFrom or ChecksumSeed.
+	Checksums []ChecksumSpec
+	// ComputeChecksum computes the hex encoded ChecksumHash checksum of the download and
+	// returns it in `Result.Checksum`, e.g. to record the checksum of a download for pinning
+	// later. The download is still validated if Checksum is set.
+	ComputeChecksum bool
+	// ChecksumFilenameMatcher decides which entry of a checksum file applies to the download,
+	// e.g. to ignore a leading `./` or directories in the filename column. It is called with the
+	// filename of each entry and the file name of the download. Defaults to an exact match.
+	ChecksumFilenameMatcher ChecksumFilenameMatcher
+	// DiagnosticHash is an optional secondary hash computed alongside the checksum. If set, a
+	// checksum mismatch error includes its digest of the downloaded content, which helps to
+	// triage corruption. The mismatch error always includes the computed checksum and the
+	// number of bytes read.
+	DiagnosticHash crypto.Hash
+	// ChecksumSeed is an optional hash of type ChecksumHash that has already consumed the bytes
+	// preceding the download, e.g. a partial download persisted with SaveHashState. The checksum
+	// is validated over those bytes followed by the downloaded bytes. ChecksumSeed itself is
+	// not modified. Sizes listed in checksum files are not validated when it is set.
+	ChecksumSeed hash.Hash
+	// ChunkChecksums holds the expected hex encoded ChecksumHash checksums of each ChunkSize
+	// byte chunk of the download, the last of which may be shorter. Each chunk is validated as
+	// soon as it has been read, failing the download with an error wrapping ErrChunkMismatch
+	// on the first mismatch, without waiting for the rest of the download.
+	ChunkChecksums []string
+	// ChunkSize is the size of the chunks in ChunkChecksums.
+	ChunkSize int64
+	// BufferSize is the size of the buffer used to copy the download. If set, copy buffers
+	// and checksum hashers are pooled and reused across downloads to reduce allocations under
+	// heavy concurrency.
+	BufferSize int
+	// MaxBytes is the maximum number of bytes to write, after any decompression. Larger
+	// downloads fail with an error wrapping ErrTooLarge, as soon as the Content-Length is
+	// known to be too large if possible. Defaults to unlimited if 0.
+	MaxBytes int64
+	// VerifyContentLength checks that the number of bytes received matches a positive
+	// Content-Length of the response, failing with an error wrapping ErrSizeMismatch
+	// otherwise, which is retried when downloading to a file. Set to `false` for servers that
+	// send bogus lengths. Defaults to `true` if nil.
+	VerifyContentLength *bool
+	// MinBytes is the minimum number of bytes to write, after any decompression and including
+	// any AppendFrom offset. Smaller downloads fail with an error wrapping ErrEmptyResponse if
+	// nothing was received, and ErrShortDownload otherwise. Defaults to no minimum if 0.
+	MinBytes int64
+	// IdleConnTimeout is how long idle connections are kept open for reuse, e.g. to keep them
+	// across the idle periods of a daemon downloading in bursts. Like MaxIdleConnsPerHost, it
+	// only takes effect if set and the transport of HTTPClient is an `*http.Transport` (or nil),
+	// which is then cloned with the setting applied. The clone is shared by all downloads with
+	// the same settings, and the transport of HTTPClient itself is left untouched.
+	IdleConnTimeout time.Duration
+	// MaxIdleConnsPerHost is the number of idle connections kept open per host for reuse. See
+	// IdleConnTimeout for when it takes effect.
+	MaxIdleConnsPerHost int
+	// AcceptStatus is an optional predicate deciding which status codes of the response are
+	// accepted as a successful download of the whole resource, e.g. to also accept 203 from a
+	// caching proxy. Defaults to only accepting 200. FromMirrors can override it per mirror.
+	AcceptStatus func(statusCode int) bool
+	// WrapError is an optional hook to annotate errors returned to the caller, e.g. with
+	// correlation IDs or structured fields. It is called once with the name of the exported
+	// operation that failed, such as `ToFile` or `ToWriter` (which also covers FromURL), and the
+	// error it would otherwise have returned, which is wrapped with errors.Wrap as usual. The
+	// hook should preserve the error chain, e.g. by wrapping err, so that errors.Is and
+	// errors.As keep working. Errors are returned as is if nil.
+	WrapError func(op string, err error) error
+	// ExpectedSize is the size of the download if known in advance, e.g. from a manifest. It is
+	// used in place of the Content-Length for progress bars and the MaxBytes check if the
+	// server doesn't send one, e.g. for chunked responses. It isn't validated.
+	ExpectedSize int64
+	// AppendFrom is the number of bytes of the download the caller already has, e.g. from a
+	// previous download of a growing file. Only the bytes from that offset onwards are written,
+	// requested with a Range request. If the server ignores the Range request, the bytes before
+	// the offset are skipped. Checksums are validated over the whole download, so Checksum
+	// requires ChecksumSeed to have consumed the bytes the caller already has. Only supported
+	// by ToWriter and FromURL.
+	AppendFrom int64
+	// Logger is an optional logger for advisory messages, such as a warning the first time
+	// MD5 or SHA1 is used as the ChecksumHash.
+	Logger Logger
+	// DisableWeakHashWarning suppresses the warning logged when MD5 or SHA1 is used.
+	DisableWeakHashWarning bool
+	// JSONEvents is an optional writer that receives the events of the download (start,
+	// progress, retry, complete and error) as newline-delimited JSON, one Event per line, e.g.
+	// to drive downloads from another process. It is a machine-readable alternative to Logger
+	// and ProgressBars.
+	JSONEvents io.Writer
+	// Precheck is an optional set of checks made against a HEAD request before downloading.
+	Precheck *Precheck
+	// VerifyStoreChecksumHeader validates the download against the checksum an object store
+	// reports in the StoreChecksumHeader response header, hex or base64 encoded, instead of
+	// Checksum.
+	VerifyStoreChecksumHeader bool
+	// StoreChecksumHeader is the header VerifyStoreChecksumHeader reads the ChecksumHash
+	// checksum from. Defaults to `x-amz-checksum-sha256` or `x-amz-checksum-sha1`.
+	StoreChecksumHeader string
+	// VerifyContentMD5 validates the download against the base64 encoded MD5 checksum in the
+	// Content-MD5 response header, as set by many object stores, instead of Checksum.
+	// ChecksumHash must be zero or MD5. Downloads served without the header fail unless
+	// ContentMD5Optional is set.
+	VerifyContentMD5 bool
+	// ContentMD5Optional makes VerifyContentMD5 log a warning to Logger rather than fail if
+	// the Content-MD5 header is absent, in which case the download isn't validated.
+	ContentMD5Optional bool
+	// AllowedFinalHosts restricts the hosts the download may be served from after following
+	// redirects, e.g. `*.mycdn.com`. Downloads served from any other host fail with an error
+	// wrapping ErrDisallowedHost before any bytes are read. Any host is allowed if empty.
+	AllowedFinalHosts []string
+	// MutableSourceGuard makes a conditional HEAD request once the download has completed to
+	// check that the ETag and Last-Modified time of the resource haven't changed while it was
+	// being downloaded, failing with an error wrapping ErrResourceChanged if they have. This
+	// costs an extra request. Resources served without either header aren't checked.
+	MutableSourceGuard bool
+	// IfNoneMatch is an ETag, e.g. `Result.ETag` of a previous download, sent in an
+	// If-None-Match header to only download the resource if it has changed. If the server
+	// responds 304 Not Modified, the download fails with an error wrapping ErrNotModified
+	// before anything is written or validated.
+	IfNoneMatch string
+	// IfModifiedSince is a time, e.g. `Result.LastModified` of a previous download, sent in an
+	// If-Modified-Since header to only download the resource if it has been modified since,
+	// as for IfNoneMatch.
+	IfModifiedSince time.Time
+	// Timeout limits the time the whole download may take, including all retries and any
+	// mirrors tried, regardless of any timeout of the HTTP client, so that one client can be
+	// shared by downloads of very different sizes. A download that times out fails with an
+	// error wrapping context.DeadlineExceeded. Defaults to no timeout if 0.
+	Timeout time.Duration
+	// MinThroughput aborts a download whose throughput, in bytes per second, stays below it
+	// for ThroughputWindow, e.g. because the mirror is overloaded. The download fails with an
+	// error wrapping ErrTooSlow, which is retried when downloading to a file. Defaults to no
+	// minimum if 0.
+	MinThroughput int64
+	// ThroughputWindow is the sliding window the throughput is measured over for
+	// MinThroughput. Defaults to 30 seconds.
+	ThroughputWindow time.Duration
+	// Concurrency is the number of ranges ToFile downloads concurrently, written straight to
+	// their offsets in the temp file, if the server advertises support for Range requests and
+	// reports the Content-Length in response to a HEAD request. The checksum is validated over
+	// the assembled file. Downloads fall back to a single stream otherwise, if Concurrency is
+	// less than 2, or if combined with FileOptions.Resume, FileOptions.DestTemplate,
+	// FileOptions.Decompress, DecodeContentEncoding, VerifyStoreChecksumHeader,
+	// VerifyContentMD5, ChunkChecksums, ChecksumSeed, URLRefresh, MutableSourceGuard,
+	// MinThroughput, MaxBytesPerSecond, Precheck or DownloadIfChanged. Not used by other
+	// downloads.
+	Concurrency int
+	// MaxBytesPerSecond limits the rate the response body is read at, e.g. on metered
+	// connections. Defaults to unlimited if 0.
+	MaxBytesPerSecond int64
 	// ProgressBars is the configuration of progress bars output. Set to `nil` (default) to disable.
 	ProgressBars *ProgressBarOptions
+	// ProgressFunc is called with the bytes downloaded so far after each read from the
+	// response, e.g. to show progress in a GUI. `total` is -1 if the Content-Length is
+	// unknown. A final call with `downloaded == total` is made when the download succeeds. It
+	// can be used alongside ProgressBars.
+	ProgressFunc func(downloaded, total int64)
 	// Retries is the number of retries for retriable errors. Defaults to 5 if unset. Set to -1 for
 	// infinite retries.
 	Retries int
 	// RetryInterval is the interval between retries.
 	RetryInterval time.Duration
+	// SignRequest is an optional hook invoked with the fully constructed request immediately
+	// before it is sent, on every attempt. Use it to attach computed signatures (e.g. AWS SigV4
+	// or HMAC). A non-nil error aborts the download.
+	SignRequest func(*http.Request) error
+	// Offline disables all network requests. ToFile succeeds only if `dest` already exists
+	// and matches the checksum (if configured, which must be a checksum string or a local file
+	// path), otherwise it returns an error wrapping ErrOffline. Other downloads always fail
+	// with an error wrapping ErrOffline.
+	Offline bool
+	// Vars holds values for `{name}` placeholders in the `src` URL passed to ToFile, ToWriter
+	// and ToFiles, e.g. `https://{mirror}/path/{version}/artifact`. Placeholders are only
+	// expanded if Vars is non-empty, in which case any placeholder without a value is an error.
+	Vars map[string]string
+	// Headers are optional headers set on every request made for the download, including
+	// retries and requests resuming it, e.g. an Authorization header for a private artifact
+	// store or a custom User-Agent. A Host header sets the host of the request. Headers set by
+	// the download itself, such as Range or Accept, take precedence. They aren't sent when
+	// fetching a checksum file from a URL, which may be on another host.
+	Headers http.Header
+	// Accept is an optional value for the Accept header of the request, used to select a
+	// specific representation from content-negotiating servers.
+	Accept string
+	// StrictChecksum makes downloads fail if no checksum is configured, rather than silently
+	// skipping validation. Combine with Result.ChecksumVerified to assert that the integrity
+	// of a download was actually checked.
+	StrictChecksum bool
+	// URLExpiry is the time at which a signed `src` URL expires. Requests are not started
+	// with a URL that has expired, failing with an error wrapping ErrURLExpired unless
+	// URLRefresh is set.
+	URLExpiry time.Time
+	// URLRefresh returns a freshly signed `src` URL. If set, it is called before every retry
+	// and before the first request if the URL is close to URLExpiry. A 403 response is also
+	// retried with a refreshed URL, as it is most likely caused by the URL having expired.
+	URLRefresh func() (string, error)
+	// VerifyFromFilename uses the hex digest embedded in the basename of the `src` URL as the
+	// checksum, e.g. for content-addressed artifacts named `artifact-<sha256>.tar.gz`. The hash
+	// function is inferred from the length of the digest (MD5, SHA1, SHA256 or SHA512). It
+	// cannot be combined with Checksum.
+	VerifyFromFilename bool
+	// FilenameChecksumPattern overrides how the digest is found by VerifyFromFilename. The
+	// first submatch, or the whole match if there are no submatches, is used as the digest.
+	FilenameChecksumPattern *regexp.Regexp
+	// MaxRedirects is the maximum number of redirects to follow, after which the download
+	// fails with an error wrapping ErrTooManyRedirects. If 0, defaults to the policy of
+	// HTTPClient's CheckRedirect if set, or else to that of net/http, which stops at the 10th
+	// redirect. Negative values disallow redirects entirely.
+	MaxRedirects int
+	// DecodeContentEncoding requests gzip, deflate and brotli Content-Encodings and decodes
+	// them. Decoding is applied before any FileOptions.Decompress, and the checksum is
+	// validated over the decoded bytes only if FileOptions.ChecksumOfDecompressed is set.
+	// Progress bars, ProgressFunc and the Content-Length reflect the encoded bytes.
+	DecodeContentEncoding bool
+
+	// batchProgress is set when downloading as part of a batch with an aggregate progress bar.
+	batchProgress *jobProgress
+	// decompress and checksumOfDecompressed are set from FileOptions.
+	decompress             Decompression
+	checksumOfDecompressed bool
+	// signedURL is set by ToFile so that refreshed URLs are kept across restarted downloads.
+	signedURL *signedURL
+	// conditional is set by DownloadIfChanged, or from IfNoneMatch and IfModifiedSince, to
+	// make requests conditional.
+	conditional *conditionalRequest
+	// clock is the source of time for retries and URL expiry. Defaults to the real clock.
+	clock clock
+	// rateLimiter is set by Downloader so that its downloads share MaxBytesPerSecond.
+	rateLimiter *rateLimiter
+	// fileHashers is set by ToFile to compute the checksum files of the bytes written.
+	fileHashers []*checksumFileHasher
+	// onResponse is set by ToFile to inspect the response before the body is read.
+	onResponse func(resp *http.Response) error
+	// retryBar is set by ToFile so that restarted downloads reuse the same progress bar.
+	retryBar *retryBar
+	// events is set once the download has started emitting JSONEvents.
+	events *eventEmitter
+	// ifRange is set by ToFile to the ETag of a partial download being resumed, so that the
+	// rest of a changed resource isn't appended to it.
+	ifRange string
+	// restartIgnoredRange is set by ToFile to restart a partial download being resumed in
+	// full if the server ignores the Range request or responds with another range, rather
+	// than skipping the bytes before AppendFrom, as they may be of another version of the
+	// resource.
+	restartIgnoredRange bool
+	// rejectDirectories is set by ToFile to refuse to save directory listings.
+	rejectDirectories bool
 }
 
 // FileOptions holds the possible configuration options to download to a file.
@@ -61,9 +385,120 @@ type FileOptions struct {
 	// Options is the common set of downloader options.
 	Options
 	// Mkdirs is the option to create parent directories of target directory if they don't
-	// exist. Use `download.MkdirAll` or `download.MkdirNone` (or any `*bool`). Defaults to
-	// `download.MkdirAll`.
+	// exist. Use `download.MkdirAll`, `download.MkdirParentOnly` or `download.MkdirNone` (or
+	// any `*bool`). Defaults to `download.MkdirAll`.
 	Mkdirs Mkdirs
+	// FileMode is the permissions of the downloaded file, set explicitly so that they aren't
+	// affected by the umask, e.g. 0755 for an executable. Defaults to 0600 if 0.
+	FileMode os.FileMode
+	// DirMode is the permissions of directories created for `dest` (before the umask).
+	// Defaults to 0700 if 0.
+	DirMode os.FileMode
+	// IfExists is the policy when `dest` already exists. Defaults to `IfExistsOverwrite`. The
+	// existing file is checked before any request is made, although a checksum URL is
+	// fetched to check it against. Cannot be combined with DestTemplate.
+	IfExists IfExists
+	// Decompress is the decompression to apply to the downloaded bytes before writing them to
+	// `dest`. Defaults to `download.DecompressNone`.
+	Decompress Decompression
+	// ChecksumOfDecompressed validates the checksum against the decompressed bytes rather than
+	// the downloaded (compressed) bytes, matching how most projects publish checksums. When
+	// the checksum is read from a file listing multiple files, it is looked up by the
+	// decompressed file name (e.g. `foo` for `foo.gz`). Only used if Decompress is set.
+	ChecksumOfDecompressed bool
+	// PostVerify is an optional hook invoked with the path of the downloaded file after it has
+	// been moved to `dest`, e.g. to check for expected magic bytes or that a binary runs. A
+	// non-nil error fails the download.
+	PostVerify func(path string) error
+	// RemoveOnPostVerifyError removes `dest` if PostVerify returns an error.
+	RemoveOnPostVerifyError bool
+	// WriteChecksumFile writes a checksum file alongside `dest` after a successful download for
+	// each of the ChecksumFileHashes, named `dest` plus an extension for the hash (e.g.
+	// `.sha256`) and in the `CHECKSUM  FILENAME` format. The checksums are computed from the
+	// bytes written to `dest` as they are downloaded, in a single pass.
+	WriteChecksumFile bool
+	// ChecksumFileHashes are the hashes to write checksum files for if WriteChecksumFile is
+	// set, named by ChecksumFileName. Supports any available hash, e.g. MD5, SHA1, SHA224,
+	// SHA256, SHA384, SHA512, SHA3-256, SHA3-512 and BLAKE2b-512. Defaults to ChecksumHash.
+	ChecksumFileHashes []crypto.Hash
+	// DirectWrite writes the download straight to `dest`, truncating it first, rather than
+	// downloading to a temp file in the same directory and renaming it to `dest` on success.
+	// This loses atomicity: `dest` is overwritten as soon as the download starts and is removed
+	// if the download fails. Only use this on filesystems where creating and renaming temp
+	// files is slow or unsupported (e.g. some FUSE or network filesystems).
+	DirectWrite bool
+	// TempFileFunc is an optional func to create the temp file to download to, given the
+	// directory and base name of `dest`. The file is renamed (or copied, if renaming fails)
+	// to `dest` on success and removed on failure. Defaults to creating a hidden file
+	// prefixed with `.tmp-` in the same directory as `dest`.
+	TempFileFunc func(dir, base string) (*os.File, error)
+	// Promote is an optional func to place the completed temp file at `dest`, replacing the
+	// default atomic rename, e.g. to also hardlink it into a content-addressed store. The temp
+	// file is removed after Promote returns, so it must be renamed, linked or copied to keep
+	// it. Cannot be combined with DirectWrite.
+	Promote func(tempPath, dest string) error
+	// Resume downloads to `dest` with a `.part` suffix (see PartFileName), which is kept if
+	// the download fails. If it already exists, the download resumes from its end with a
+	// Range request. The checksum is validated over the whole file: by default the existing
+	// bytes are read back to hash them. When a download fails, its ResumeState is saved next to
+	// the `.part` file, so that it is only resumed from the same URL and while the ETag of the
+	// resource is unchanged; otherwise it restarts. Cannot be combined with DirectWrite,
+	// TempFileFunc, DestTemplate, Decompress, DecodeContentEncoding or ChecksumSeed.
+	Resume bool
+	// ResumeFrom resumes the partial download kept by Resume from this offset rather than
+	// from its end, discarding any bytes after it, e.g. to resume from the last offset known
+	// to have been flushed to disk. Requires Resume.
+	ResumeFrom int64
+	// TrustHashState restores the checksum of the existing bytes of a resumed download from
+	// the hash state saved alongside the `.part` file when a previous attempt failed (see
+	// ResumeStateFileName and HashStateFileName), rather than reading them back. This only
+	// hashes the newly downloaded bytes, but trusts that the `.part` file hasn't been modified
+	// since. Falls back to reading the existing bytes if there is no saved state.
+	TrustHashState bool
+	// OnTempReady is an optional hook invoked with the path of the temp file once it has been
+	// fully written and validated, but before it is moved to `dest`, e.g. to scan it for
+	// malware. A non-nil error fails the download and the temp file is removed, unless
+	// KeepPartialOnError or CleanupDecider say to keep it. With DirectWrite it is invoked with
+	// `dest` itself.
+	OnTempReady func(path string) error
+	// Verifier is an optional Verifier of the provenance of the download, e.g. a signature
+	// check, invoked once it has been fully written and validated, before OnTempReady. A
+	// rejected download fails with an error wrapping ErrVerificationFailed and is cleaned up
+	// like one rejected by OnTempReady.
+	Verifier Verifier
+	// DryRun makes only a HEAD request, following redirects, and returns a Result whose DryRun
+	// describes the download that would have been made: where it would be served from, its size
+	// and Content-Type, and whether it would be skipped. Result.Path is the file that would be
+	// written. Nothing is written to disk.
+	DryRun bool
+	// ETagFile is the file DownloadIfChanged stores the ETag of `dest` in, to make the next
+	// request for it conditional on the ETag. Defaults to `dest` with a `.etag` suffix.
+	ETagFile string
+	// DisableETagFile stops DownloadIfChanged reading and writing the ETag file, for callers
+	// that store ETags themselves.
+	DisableETagFile bool
+	// KeepPartialOnError keeps the partially downloaded temp file (or `dest`, with DirectWrite)
+	// for inspection if the download fails, rather than removing it. Its path is included in
+	// the returned error.
+	KeepPartialOnError bool
+	// CleanupDecider is an optional func deciding, given the error a download failed with,
+	// whether to keep the partial download as KeepPartialOnError does. Overrides
+	// KeepPartialOnError if set.
+	CleanupDecider func(err error) (keep bool)
+	// DestTemplate treats `dest` as a template resolved from the response before anything is
+	// written, e.g. `cache/{final-host}/{filename}`. The placeholders are `{filename}` (from the
+	// Content-Disposition header, falling back to the last element of the final URL path),
+	// `{etag}` (the ETag without quotes) and `{final-host}` (the host after redirects). The
+	// download is written to a temp file in the directory of the placeholder-free prefix of
+	// `dest` and moved to the resolved path, which is returned in `Result.Path`. Cannot be
+	// combined with DirectWrite or Offline.
+	DestTemplate bool
+
+	// openFiles is set by ToFiles to limit the number of files open at once.
+	openFiles *semaphore
+	// sequential is set by ToFiles to download in a single stream whatever the Concurrency,
+	// so that each download is a single connection to its host.
+	sequential bool
 }
 
 // ProgressBarOptions holds the configuration for progress bars if required.
@@ -88,54 +523,316 @@ var (
 	MkdirAll = Mkdirs(newBool(true))
 	// MkdirNone is used to create no intermediate directories.
 	MkdirNone = Mkdirs(newBool(false))
+	// MkdirParentOnly is used to create just the directory of the target file, and only if
+	// its own parent exists, so that a mistyped path doesn't create a deep directory tree.
+	MkdirParentOnly = Mkdirs(newBool(true))
 )
 
 // ToFile downloads the specified `src` URL to `dest` file using
 // the specified `FileOptions`.
+// `src` may also be a `file://` URL or an absolute local path.
 func ToFile(src, dest string, options FileOptions) error {
-	u, err := url.Parse(src)
+	return ToFileContext(context.Background(), src, dest, options)
+}
+
+// ToFileContext is the same as ToFile but aborts the download, removing the temp file, as soon
+// as `ctx` is done, returning an error wrapping `ctx.Err()`.
+func ToFileContext(ctx context.Context, src, dest string, options FileOptions) error {
+	_, err := toFile(ctx, src, dest, options)
+	return err
+}
+
+// ToFileWithResult is the same as ToFile but also returns the `Result` of the download.
+func ToFileWithResult(src, dest string, options FileOptions) (Result, error) {
+	return toFile(context.Background(), src, dest, options)
+}
+
+// ToFileURL is the same as ToFile but downloads the already parsed `src` URL. `Vars` are not
+// expanded in `src`.
+func ToFileURL(src *url.URL, dest string, options FileOptions) error {
+	_, err := toFileURL(context.Background(), src, dest, options)
+	return err
+}
+
+func toFile(ctx context.Context, src, dest string, options FileOptions) (Result, error) {
+	wrap := takeWrapError(&options.Options)
+	u, err := parseSrc(src, options.Vars)
 	if err != nil {
-		return errors.Wrap(err, "invalid src URL")
+		return Result{}, wrapError(wrap, "ToFile", err)
 	}
+	result, err := toFileURL(ctx, u, dest, options)
+	return result, wrapError(wrap, "ToFile", err)
+}
 
-	targetDir := filepath.Dir(dest)
-	if err = createDir(targetDir, options.Mkdirs == nil || *options.Mkdirs); err != nil {
-		return err
+func toFileURL(ctx context.Context, u *url.URL, dest string, options FileOptions) (result Result, err error) {
+	if events := startEvents(&options.Options, u); events != nil {
+		result, err = toFileURL(ctx, u, dest, options)
+		events.finish(result, err)
+		return result, err
 	}
+	// attempts is the number of requests made for the download, which is reported even if
+	// it fails afterwards.
+	var attempts int
+	defer func() {
+		if err != nil {
+			result.Attempts = attempts
+		}
+	}()
+	wrap := takeWrapError(&options.Options)
+	defer func() { err = wrapError(wrap, "ToFile", err) }()
+	ctx, cancelTimeout := withTimeout(ctx, &options.Options)
+	defer cancelTimeout()
 
-	targetName := filepath.Base(dest)
-	f, err := ioutil.TempFile(targetDir, ".tmp-"+targetName)
+	if u == nil {
+		return Result{}, errors.New("src URL is nil")
+	}
+	u = localFileURL(u)
+	if err := checkScheme(u); err != nil {
+		return Result{}, err
+	}
+
+	if err := resolveFilenameChecksum(u, &options.Options); err != nil {
+		return Result{}, err
+	}
+	if err := resolveAcceptableChecksums(&options.Options); err != nil {
+		return Result{}, err
+	}
+	if err := resolveContentMD5(&options.Options); err != nil {
+		return Result{}, err
+	}
+	if err := resolveChecksums(&options.Options); err != nil {
+		return Result{}, err
+	}
+	if err := resolveChecksumHash(&options.Options); err != nil {
+		return Result{}, err
+	}
+	resolveConditional(&options.Options)
+	err = checkStrictChecksum(options.Options)
 	if err != nil {
-		return errors.Wrap(err, "failed to create temp file")
+		return Result{}, err
+	}
+
+	if options.DestTemplate && (options.DirectWrite || options.Offline) {
+		return Result{}, errors.New("DestTemplate cannot be combined with DirectWrite or Offline")
+	}
+
+	if len(options.Checksums) > 0 && (options.Offline || options.Resume) {
+		return Result{}, errors.New("Checksums cannot be combined with Offline or Resume")
+	}
+	if options.Offline {
+		return verifyOffline(ctx, u, dest, options)
+	}
+
+	if options.AppendFrom > 0 {
+		return Result{}, errors.New("AppendFrom is not supported when downloading to a file")
+	}
+	if options.DirectWrite && options.Promote != nil {
+		return Result{}, errors.New("DirectWrite and Promote are mutually exclusive")
+	}
+	if options.Resume && (options.DirectWrite || options.TempFileFunc != nil || options.DestTemplate) {
+		return Result{}, errors.New("Resume cannot be combined with DirectWrite, TempFileFunc or DestTemplate")
+	}
+	if options.ResumeFrom != 0 && (!options.Resume || options.ResumeFrom < 0) {
+		return Result{}, errors.New("ResumeFrom requires Resume and must not be negative")
+	}
+	if options.Resume && (options.Decompress != DecompressNone || options.DecodeContentEncoding || options.ChecksumSeed != nil) {
+		return Result{}, errors.New("Resume cannot be combined with Decompress, DecodeContentEncoding or ChecksumSeed")
+	}
+	skip, skipped, err := checkExisting(ctx, u, dest, options)
+	if err != nil || skip {
+		if skip && options.DryRun {
+			skipped.DryRun = &DryRunReport{URL: u, Size: -1, Reason: "destination exists"}
+		}
+		return skipped, err
+	}
+	if options.DryRun {
+		return dryRun(ctx, u, dest, options)
+	}
+
+	targetDir := filepath.Dir(dest)
+	if options.DestTemplate {
+		targetDir = destTemplateDir(dest)
+		template := dest
+		options.Options.onResponse = func(resp *http.Response) error {
+			resolved, err := resolveDestTemplate(template, resp)
+			if err != nil {
+				return err
+			}
+			dest = resolved
+			return nil
+		}
+	}
+	if err = createDir(targetDir, options.Mkdirs, options.DirMode); err != nil {
+		return Result{}, err
+	}
+
+	var fileHashers []*checksumFileHasher
+	if options.WriteChecksumFile {
+		hashTypes := options.ChecksumFileHashes
+		if len(hashTypes) == 0 {
+			hashTypes = []crypto.Hash{options.ChecksumHash}
+		}
+		if fileHashers, err = newChecksumFileHashers(hashTypes); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if err = options.openFiles.acquire(ctx); err != nil {
+		return Result{}, err
+	}
+	defer options.openFiles.release()
+
+	var (
+		f       *os.File
+		partial *partialDownload
+		hashers = fileHashers
+	)
+	switch {
+	case options.Resume:
+		if partial, err = openPartialDownload(ctx, u, dest, &options, fileHashers); err != nil {
+			return Result{}, err
+		}
+		f = partial.f
+		if partial.tracker != nil {
+			hashers = append(append([]*checksumFileHasher{}, fileHashers...), partial.tracker)
+		}
+	case options.DirectWrite:
+		f, err = os.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return Result{}, wrapDestinationError(err, "failed to create destination file")
+		}
+	default:
+		tempFile := options.TempFileFunc
+		if tempFile == nil {
+			tempFile = defaultTempFile
+		}
+		f, err = tempFile(targetDir, filepath.Base(dest))
+		if err != nil {
+			return Result{}, wrapDestinationError(err, "failed to create temp file")
+		}
 	}
 
-	err = downloadFile(u, f, options.Options)
+	options.Options.decompress = options.Decompress
+	options.Options.checksumOfDecompressed = options.ChecksumOfDecompressed
+	options.Options.signedURL = newSignedURL(u, options.Options)
+	options.Options.rejectDirectories = true
+	handled := false
+	if parallelSupported(options) {
+		result, handled, err = downloadParallel(ctx, u, f, options.Options, hashers)
+	}
+	if !handled {
+		result, err = downloadFile(ctx, u, f, options.Options, hashers)
+	}
+	attempts = result.Attempts
+	if err == nil && options.FileMode != 0 {
+		if err = f.Chmod(options.FileMode); err != nil {
+			err = errors.Wrap(err, "failed to set file mode")
+		}
+	}
+	if err == nil && options.DestTemplate {
+		err = createDir(filepath.Dir(dest), options.Mkdirs, options.DirMode)
+	}
 	if err != nil {
-		_ = f.Close()           // #nosec
+		_ = f.Close() // #nosec
+		if partial != nil {
+			partial.saveState()
+		}
+		if keepPartial(err, options) {
+			return Result{}, errors.Wrapf(err, "failed to download (partial download kept at %s)", f.Name())
+		}
 		_ = os.Remove(f.Name()) // #nosec
-		return errors.Wrap(err, "failed to download")
+		return Result{}, errors.Wrap(err, "failed to download")
 	}
 	err = f.Close()
 	if err != nil {
 		_ = os.Remove(f.Name()) // #nosec
-		return errors.Wrap(err, "failed to close temp file")
+		return Result{}, errors.Wrap(err, "failed to close temp file")
 	}
 
-	if err = renameFile(f.Name(), dest); err != nil {
-		return err
+	if options.Verifier != nil {
+		meta := result
+		meta.Path = dest
+		if err = verifyFile(options.Verifier, f.Name(), meta); err != nil {
+			if keepPartial(err, options) {
+				return Result{}, errors.Wrapf(err, "rejected download kept at %s", f.Name())
+			}
+			_ = os.Remove(f.Name()) // #nosec
+			return Result{}, err
+		}
+	}
+	if options.OnTempReady != nil {
+		if err = options.OnTempReady(f.Name()); err != nil {
+			err = errors.Wrap(err, "downloaded file rejected")
+			if keepPartial(err, options) {
+				return Result{}, errors.Wrapf(err, "rejected download kept at %s", f.Name())
+			}
+			_ = os.Remove(f.Name()) // #nosec
+			return Result{}, err
+		}
 	}
 
-	return nil
+	if options.Promote != nil {
+		err = options.Promote(f.Name(), dest)
+		_ = os.Remove(f.Name()) // #nosec
+		if err != nil {
+			return Result{}, errors.Wrap(err, "failed to promote temp file to destination")
+		}
+	} else if !options.DirectWrite {
+		if err = renameFile(f.Name(), dest); err != nil {
+			return Result{}, err
+		}
+	}
+	if partial != nil {
+		partial.removeState()
+	}
+
+	if options.PostVerify != nil {
+		if err = options.PostVerify(dest); err != nil {
+			if options.RemoveOnPostVerifyError {
+				_ = os.Remove(dest) // #nosec
+			}
+			return Result{}, errors.Wrap(err, "post-download verification failed")
+		}
+	}
+
+	for _, h := range fileHashers {
+		if err = writeChecksumFile(dest, h.hashType, h.digest()); err != nil {
+			return Result{}, err
+		}
+	}
+
+	result.Path = dest
+	return result, nil
+}
+
+func keepPartial(err error, options FileOptions) bool {
+	if options.CleanupDecider != nil {
+		return options.CleanupDecider(err)
+	}
+	return options.KeepPartialOnError || options.Resume
+}
+
+func defaultTempFile(dir, base string) (*os.File, error) {
+	return ioutil.TempFile(dir, ".tmp-"+base)
 }
 
 func renameFile(src, dest string) error {
 	err := os.Rename(src, dest)
 	if err != nil {
-		// Rename failed, try to copy file.
-		destF, err := os.Create(dest)
+		// Rename failed, try to copy file, keeping its mode.
+		fi, err := os.Stat(src)
+		if err != nil {
+			return errors.Wrap(err, "failed to stat source file")
+		}
+		destF, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
 		if err != nil {
 			return errors.Wrap(err, "failed to create target file")
 		}
+		if err = destF.Chmod(fi.Mode().Perm()); err != nil {
+			_ = destF.Close()
+			_ = os.Remove(destF.Name())
+			return errors.Wrap(err, "failed to set mode of target file")
+		}
 		f, err := os.Open(src)
 		if err != nil {
 			_ = os.Remove(dest)
@@ -161,115 +858,555 @@ func renameFile(src, dest string) error {
 	return nil
 }
 
-func createDir(dir string, mkdirs bool) error {
+func createDir(dir string, mkdirs Mkdirs, mode os.FileMode) error {
+	if mode == 0 {
+		mode = 0700
+	}
 	if _, err := os.Stat(dir); err != nil {
 		if !os.IsNotExist(err) {
 			return errors.Wrap(err, "failed to check destination directory")
 		}
-		if !mkdirs {
-			return errors.Errorf("directory %s does not exist", dir)
+		if mkdirs != nil && !*mkdirs {
+			return &destinationError{errors.Errorf("directory %s does not exist", dir)}
+		}
+		if mkdirs == MkdirParentOnly {
+			if _, err = os.Stat(filepath.Dir(dir)); err != nil {
+				return &destinationError{errors.Errorf("directory %s does not exist and neither does its parent", dir)}
+			}
+			if err = os.Mkdir(dir, mode); err != nil {
+				return wrapDestinationError(err, "failed to create destination directory")
+			}
+			return nil
 		}
-		err = os.MkdirAll(dir, 0700)
+		err = os.MkdirAll(dir, mode)
 		if err != nil {
-			return errors.Wrap(err, "failed to create destination directory")
+			return wrapDestinationError(err, "failed to create destination directory")
 		}
 	}
 
 	return nil
 }
 
-func downloadFile(u *url.URL, f *os.File, options Options) error {
-	err := FromURL(u, f, options)
+// downloadFile downloads u to f, retrying from the start if the download is cut short.
+// All fileHashers are fed the bytes written to f.
+func downloadFile(ctx context.Context, u *url.URL, f *os.File, options Options, fileHashers []*checksumFileHasher) (Result, error) {
+	options.retryBar = &retryBar{}
+	defer options.retryBar.finish()
+	options.fileHashers = fileHashers
+	var result Result
+	downloader := func() (err error) {
+		for {
+			if err := resetFile(f, options.AppendFrom); err != nil {
+				return err
+			}
+			for _, h := range fileHashers {
+				if err := h.reset(); err != nil {
+					return err
+				}
+			}
+			r, err := fromURL(ctx, u, f, options)
+			r.Attempts += result.Attempts
+			result = r
+			if options.AppendFrom == 0 || !errors.Is(err, errRangeIgnored) {
+				return err
+			}
+			// The server doesn't support resuming the partial download, so download it in full.
+			options.AppendFrom, options.ChecksumSeed, options.ifRange = 0, nil, ""
+			for _, h := range fileHashers {
+				h.prefix = nil
+			}
+		}
+	}
+	err := retryAfter(ctx, getRetries(options), options.events.retrying(downloader), options.RetryInterval, getClock(options))
 	if err != nil {
-		return errors.Wrap(err, "failed to download to temp file")
+		return Result{Attempts: result.Attempts}, errors.Wrap(err, "failed to download to temp file")
 	}
 
+	return result, nil
+}
+
+// resetFile truncates f to offset, the size of any partial download being resumed, and
+// seeks to its end.
+func resetFile(f *os.File, offset int64) error {
+	if err := f.Truncate(offset); err != nil {
+		return errors.Wrap(err, "failed to truncate temp file")
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "failed to seek temp file")
+	}
 	return nil
 }
 
+var srcVarPattern = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// parseSrc expands any placeholders in src using vars and parses the resulting URL.
+func parseSrc(src string, vars map[string]string) (*url.URL, error) {
+	if len(vars) > 0 {
+		var missing []string
+		src = srcVarPattern.ReplaceAllStringFunc(src, func(placeholder string) string {
+			name := placeholder[1 : len(placeholder)-1]
+			value, ok := vars[name]
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+		if len(missing) > 0 {
+			return nil, errors.Errorf("invalid src URL: no value for variables %v", missing)
+		}
+	}
+	if filepath.IsAbs(src) {
+		// Parsed as a path rather than a URL, so that e.g. Windows drive letters aren't
+		// mistaken for schemes.
+		return filePathURL(src), nil
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid src URL")
+	}
+	return u, nil
+}
+
 // ToWriter downloads the specified `src` URL to `w` writer using
 // the specified `Options`.
+// `src` may also be a `file://` URL or an absolute local path.
 func ToWriter(src string, w io.Writer, options Options) error {
-	u, err := url.Parse(src)
+	return ToWriterContext(context.Background(), src, w, options)
+}
+
+// ToWriterContext is the same as ToWriter but aborts the download as soon as `ctx` is done,
+// returning an error wrapping `ctx.Err()`.
+func ToWriterContext(ctx context.Context, src string, w io.Writer, options Options) error {
+	_, err := toWriter(ctx, src, w, options)
+	return err
+}
+
+// ToWriterWithResult is the same as ToWriter but also returns the `Result` of the download.
+func ToWriterWithResult(src string, w io.Writer, options Options) (Result, error) {
+	return toWriter(context.Background(), src, w, options)
+}
+
+func toWriter(ctx context.Context, src string, w io.Writer, options Options) (Result, error) {
+	u, err := parseSrc(src, options.Vars)
 	if err != nil {
-		return errors.Wrap(err, "invalid src URL")
+		return Result{}, wrapError(options.WrapError, "ToWriter", err)
 	}
-	return FromURL(u, w, options)
+	return fromURL(ctx, u, w, options)
+}
+
+// ToWriterURL is the same as ToWriter but downloads the already parsed `src` URL. `Vars` are
+// not expanded in `src`. It is equivalent to FromURL.
+func ToWriterURL(src *url.URL, w io.Writer, options Options) error {
+	return FromURL(src, w, options)
 }
 
 // FromURL downloads the specified `src` URL to `w` writer using
 // the specified `Options`.
+// `src` may also be a `file://` URL or an absolute local path.
 func FromURL(src *url.URL, w io.Writer, options Options) error {
+	return FromURLContext(context.Background(), src, w, options)
+}
+
+// FromURLContext is the same as FromURL but aborts the download as soon as `ctx` is done,
+// returning an error wrapping `ctx.Err()`.
+func FromURLContext(ctx context.Context, src *url.URL, w io.Writer, options Options) error {
+	_, err := fromURL(ctx, src, w, options)
+	return err
+}
+
+// FromURLWithResult is the same as FromURL but also returns the `Result` of the download.
+func FromURLWithResult(src *url.URL, w io.Writer, options Options) (Result, error) {
+	return fromURL(context.Background(), src, w, options)
+}
+
+func fromURL(ctx context.Context, src *url.URL, w io.Writer, options Options) (result Result, err error) {
+	if events := startEvents(&options, src); events != nil {
+		result, err = fromURL(ctx, src, w, options)
+		events.finish(result, err)
+		return result, err
+	}
+	var attempts int
+	defer func() { result.Attempts = attempts }()
+	wrap := takeWrapError(&options)
+	defer func() { err = wrapError(wrap, "ToWriter", err) }()
+	ctx, cancelTimeout := withTimeout(ctx, &options)
+	defer cancelTimeout()
+
+	if src == nil {
+		return Result{}, errors.New("src URL is nil")
+	}
+	src = localFileURL(src)
+	if err := checkScheme(src); err != nil {
+		return Result{}, err
+	}
+
+	if options.Offline {
+		return Result{}, errors.Wrapf(ErrOffline, "refusing to fetch %s", src)
+	}
+	if err := resolveFilenameChecksum(src, &options); err != nil {
+		return Result{}, err
+	}
+	if err := resolveAcceptableChecksums(&options); err != nil {
+		return Result{}, err
+	}
+	if err := resolveContentMD5(&options); err != nil {
+		return Result{}, err
+	}
+	if err := resolveChecksums(&options); err != nil {
+		return Result{}, err
+	}
+	if err := resolveChecksumHash(&options); err != nil {
+		return Result{}, err
+	}
+	resolveConditional(&options)
+	if err := checkStrictChecksum(options); err != nil {
+		return Result{}, err
+	}
+	if options.VerifyStoreChecksumHeader && options.Checksum != "" {
+		return Result{}, errors.New("Checksum and VerifyStoreChecksumHeader are mutually exclusive")
+	}
+	warnWeakHash(options)
+	if options.AppendFrom > 0 && options.Checksum != "" && options.ChecksumSeed == nil {
+		return Result{}, errors.New("Checksum can only be used with AppendFrom if ChecksumSeed is set")
+	}
+
 	httpClient := getHTTPClient(options)
-	var (
-		err  error
-		resp *http.Response
-	)
+	if options.Precheck != nil {
+		if err = precheck(ctx, httpClient, src, options); err != nil {
+			return Result{}, err
+		}
+	}
+	var cancel context.CancelFunc
+	if options.MinThroughput > 0 {
+		// The request is cancelled if the download is too slow.
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+	}
+	urls := options.signedURL
+	if urls == nil {
+		urls = newSignedURL(src, options)
+	}
+	var resp *http.Response
 	downloader := func() error {
-		resp, err = httpClient.Get(src.String())
+		attempts++
+		u, err := urls.next()
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 		if err != nil {
+			return errors.Wrap(err, "failed to create request")
+		}
+		setRequestHeaders(req, options)
+		if options.Accept != "" {
+			req.Header.Set("Accept", options.Accept)
+		}
+		if options.DecodeContentEncoding {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		options.conditional.setHeaders(req)
+		if options.AppendFrom > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", options.AppendFrom))
+			if options.ifRange != "" {
+				req.Header.Set("If-Range", options.ifRange)
+			}
+		}
+		if options.SignRequest != nil {
+			if err = options.SignRequest(req); err != nil {
+				return errors.Wrap(err, "failed to sign request")
+			}
+		}
+		resp, err = httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, ErrDisallowedRedirect) || errors.Is(err, ErrTooManyRedirects) || errors.Is(err, os.ErrNotExist) {
+				return err
+			}
 			return &retriableError{errors.Wrap(err, "Temporary download error")}
 		}
-		if resp.StatusCode != http.StatusOK {
+		if err = checkFinalHost(resp, options.AllowedFinalHosts); err != nil {
+			_ = resp.Body.Close() // #nosec
+			return err
+		}
+		if options.AppendFrom > 0 {
+			switch resp.StatusCode {
+			case http.StatusPartialContent:
+				if err = checkContentRange(resp, options.AppendFrom); err != nil {
+					_ = resp.Body.Close() // #nosec
+					if options.restartIgnoredRange {
+						return errRangeIgnored
+					}
+					return err
+				}
+				return nil
+			case http.StatusRequestedRangeNotSatisfiable:
+				return nil
+			case http.StatusOK:
+				if options.restartIgnoredRange {
+					_ = resp.Body.Close() // #nosec
+					return errRangeIgnored
+				}
+			}
+		}
+		if !acceptStatus(resp.StatusCode, options) {
 			defer func() { _ = resp.Body.Close() }() // #nosec
-			return errors.Errorf("received invalid status code: %d (expected %d)", resp.StatusCode, http.StatusOK)
+			if resp.StatusCode == http.StatusNotModified && options.conditional != nil {
+				return ErrNotModified
+			}
+			if resp.StatusCode == http.StatusForbidden && options.URLRefresh != nil {
+				return &retriableError{errors.Errorf("received status code %d, refreshing URL", resp.StatusCode)}
+			}
+			if options.AcceptStatus != nil {
+				return newStatusError(resp, "rejected by AcceptStatus")
+			}
+			return newStatusError(resp, fmt.Sprintf("expected %d", http.StatusOK))
 		}
 		return nil
 	}
-	retries := options.Retries
-	if retries == 0 {
-		retries = 5
-	}
-	if err = retryAfter(retries, downloader, options.RetryInterval); err != nil {
-		return errors.Wrap(err, "download failed")
+	if err = retryAfter(ctx, getRetries(options), options.events.retrying(downloader), options.RetryInterval, getClock(options)); err != nil {
+		return Result{}, errors.Wrap(err, "download failed")
 	}
 	defer func() { _ = resp.Body.Close() }() // #nosec
 
+	if options.rejectDirectories {
+		if err = checkNotDirectory(resp); err != nil {
+			return Result{}, err
+		}
+	}
+	if options.onResponse != nil {
+		if err = options.onResponse(resp); err != nil {
+			return Result{}, err
+		}
+	}
+
 	var (
-		validator checksumValidator
+		cv checksumValidator
 
-		reader io.Reader = resp.Body
+		body             = &countingReader{r: &contextReader{ctx, resp.Body}}
+		reader io.Reader = body
 	)
+	// The rate limiter wraps the response body so that the bytes are only counted once.
+	reader = newRateLimitedReader(ctx, reader, options)
 
-	if options.ProgressBars != nil && resp.ContentLength > 0 {
-		bar := newProgressBar(resp.ContentLength, options.ProgressBars.MaxWidth, options.ProgressBars.Writer)
-		bar.Start()
+	var throughput *throughputMonitor
+	if options.MinThroughput > 0 {
+		throughput = newThroughputMonitor(reader, options, cancel)
+		defer throughput.stop()
+		reader = throughput
+	}
+
+	if options.AppendFrom > 0 {
+		if reader, err = appendReader(resp, reader, options.AppendFrom); err != nil {
+			return Result{}, err
+		}
+	}
+
+	checksum := options.Checksum
+	if options.VerifyStoreChecksumHeader {
+		if checksum, err = storeChecksum(resp, options); err != nil {
+			return Result{}, err
+		}
+	}
+	if options.VerifyContentMD5 {
+		if checksum, err = contentMD5(resp, options); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if options.Precheck != nil {
+		if err = options.Precheck.check(resp); err != nil {
+			return Result{}, err
+		}
+		if options.Precheck.MaxBytes > 0 {
+			reader = &maxBytesReader{r: reader, n: options.Precheck.MaxBytes}
+		}
+	}
+	size := contentLength(resp, options)
+	if options.MaxBytes > 0 && size > options.MaxBytes && options.decompress == DecompressNone && !options.DecodeContentEncoding {
+		return Result{}, errors.Wrapf(ErrTooLarge, "size %d exceeds maximum of %d bytes", size, options.MaxBytes)
+	}
+
+	if options.batchProgress != nil {
+		reader = options.batchProgress.proxyReader(reader, size)
+	}
+	reader = options.events.progressReader(reader, size)
+
+	barTotal, barCurrent := size, int64(0)
+	if options.AppendFrom > 0 && size >= 0 {
+		barTotal, barCurrent = appendProgress(resp, size, options.AppendFrom)
+	}
+	if options.ProgressBars != nil && barTotal > 0 {
+		var bar *pb.ProgressBar
+		if options.retryBar != nil {
+			bar = options.retryBar.start(barTotal, barCurrent, options.ProgressBars)
+		} else {
+			bar = newProgressBar(barTotal, options.ProgressBars.MaxWidth, options.ProgressBars.Writer)
+			bar.Set64(barCurrent)
+			bar.Start()
+			defer bar.Finish()
+		}
 		reader = bar.NewProxyReader(reader)
-		defer func() {
-			<-time.After(bar.RefreshRate)
-			fmt.Println()
-		}()
+	}
+	reader = newProgressFuncReader(reader, options.ProgressFunc, barCurrent, barTotal)
+	progress, _ := reader.(*progressFuncReader)
+
+	// The checksum is validated over either the downloaded bytes or the decompressed bytes, in
+	// which case the checksum is looked up using the decompressed file name.
+	checksumFilename := path.Base(src.Path)
+	decompression := detectDecompression(options.decompress, src, resp)
+	sniff := options.decompress == DecompressAuto
+	contentEncoding := DecompressNone
+	if options.DecodeContentEncoding {
+		if contentEncoding, err = contentEncodingDecompression(resp); err != nil {
+			return Result{}, err
+		}
+	}
+	// decompress decodes the Content-Encoding of r before decompressing it.
+	decompress := func(r io.Reader) (io.Reader, Decompression, error) {
+		r, _, err := newDecompressionReader(contentEncoding, r, false)
+		if err != nil {
+			return nil, DecompressNone, err
+		}
+		return newDecompressionReader(decompression, r, sniff)
+	}
+	if options.checksumOfDecompressed {
+		if reader, decompression, err = decompress(reader); err != nil {
+			return Result{}, err
+		}
+		checksumFilename = decompressedName(checksumFilename, decompression)
 	}
 
-	validator, reader, err = createValidatorReader(reader, options.ChecksumHash, httpClient, options.Checksum, path.Base(src.Path))
+	cv, err = createValidator(ctx, options.ChecksumHash, httpClient, checksum, checksumFilename, options, options.ProgressBars, options.ChecksumSeed, options.BufferSize > 0)
 	if err != nil {
-		return err
+		return Result{}, errors.Wrap(err, "failed to create validator")
+	}
+	acceptChecksums(cv, options.AcceptableChecksums)
+	if options.BufferSize > 0 && options.ChecksumSeed == nil {
+		defer releaseValidator(cv)
+	}
+	if len(options.Checksums) > 0 {
+		if cv, err = newMultiValidator(ctx, cv, httpClient, checksumFilename, options); err != nil {
+			return Result{}, err
+		}
+	}
+	var (
+		observers   []io.Writer
+		diagnostics *checksumDiagnostics
+		chunks      *chunkValidator
+	)
+	if _, skipped := cv.(*noopValidator); !skipped {
+		if diagnostics, err = newChecksumDiagnostics(options.DiagnosticHash); err != nil {
+			return Result{}, err
+		}
+		observers = append(observers, cv, diagnostics)
 	}
+	if len(options.ChunkChecksums) > 0 {
+		if chunks, err = newChunkValidator(options.ChecksumHash, options.ChunkSize, options.ChunkChecksums); err != nil {
+			return Result{}, errors.Wrap(err, "failed to create chunk validator")
+		}
+		observers = append(observers, chunks)
+	}
+	computed, err := newComputedChecksum(cv, options)
+	if err != nil {
+		return Result{}, err
+	}
+	observers = append(observers, computed.observers()...)
+	reader = teeReader(reader, observers...)
 
-	if _, err = io.Copy(w, reader); err != nil {
-		return errors.Wrap(err, "failed to copy contents")
+	if !options.checksumOfDecompressed {
+		if reader, decompression, err = decompress(reader); err != nil {
+			return Result{}, err
+		}
 	}
 
-	if !validator.validate() {
-		return errors.New("checksum validation failed")
+	if options.MaxBytes > 0 {
+		reader = &maxBytesReader{r: reader, n: options.MaxBytes}
+	}
+	if sink, ok := w.(*byteSink); ok {
+		sink.grow(size, options.MaxBytes)
 	}
 
-	return nil
-}
+	// The checksum validator sees exactly the bytes written if nothing was decoded and it
+	// wasn't seeded with preceding bytes, so a checksum file for the same hash can reuse it.
+	var shared *checksumFileHasher
+	if v, ok := cv.(*validator); ok && decompression == DecompressNone && contentEncoding == DecompressNone && options.ChecksumSeed == nil {
+		if shared = sharedChecksumFileHasher(options.fileHashers, v.hashType); shared != nil {
+			defer func() {
+				if err == nil {
+					shared.sum = v.hasher.Sum(nil)
+				}
+			}()
+		}
+	}
+	w = checksumFileWriter(w, options.fileHashers, shared)
 
-func createValidatorReader(reader io.Reader, hashType crypto.Hash, httpClient *http.Client, checksum, filename string) (checksumValidator, io.Reader, error) {
-	validator, err := createValidator(hashType, httpClient, checksum, filename)
+	var written int64
+	if options.BufferSize > 0 {
+		written, err = copyBuffer(w, reader, options.BufferSize)
+	} else {
+		written, err = io.Copy(w, reader)
+	}
+	if throughput != nil {
+		throughput.stop()
+		if err != nil {
+			if err = throughput.wrapError(err); errors.Is(err, ErrTooSlow) {
+				return Result{}, &retriableError{errors.Wrap(err, "failed to copy contents")}
+			}
+		}
+	}
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to create validator")
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Result{}, &retriableError{errors.Wrap(ErrShortDownload, "failed to copy contents")}
+		}
+		return Result{}, errors.Wrap(err, "failed to copy contents")
 	}
-	return validator, io.TeeReader(reader, validator), nil
+	if err = checkMinBytes(options.AppendFrom+written, options.MinBytes); err != nil {
+		return Result{}, &retriableError{err}
+	}
+	// Checked before validating, as a truncated download would also fail validation.
+	if err = checkContentLength(resp, body.n, options); err != nil {
+		return Result{}, &retriableError{err}
+	}
+
+	if options.MutableSourceGuard {
+		// Checked before validating, as a changed resource would likely also fail validation.
+		if err = checkUnchanged(ctx, httpClient, resp, options); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if err = cv.validate(); err != nil {
+		return Result{}, diagnostics.annotate(err)
+	}
+	if chunks != nil {
+		if err = chunks.validate(); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if progress != nil {
+		progress.done()
+	}
+
+	_, skipped := cv.(*noopValidator)
+	result = Result{
+		ChecksumVerified: !skipped || chunks != nil,
+		ETag:             resp.Header.Get("ETag"),
+	}
+	computed.setResult(&result)
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		result.LastModified = lastModified
+	}
+	return result, nil
 }
 
 type noopValidator struct {
 }
 
-func (*noopValidator) validate() bool {
-	return true
+func (*noopValidator) validate() error {
+	return nil
 }
 
 func (*noopValidator) Write(p []byte) (n int, err error) {
@@ -278,38 +1415,131 @@ func (*noopValidator) Write(p []byte) (n int, err error) {
 
 var _ checksumValidator = &noopValidator{}
 
-func createValidator(hashType crypto.Hash, httpClient *http.Client, checksum, filename string) (checksumValidator, error) {
+// createValidator creates a validator for checksum. If the checksum is fetched from a URL,
+// the request is made with ctx and the headers of options, and progress is used to show the
+// progress of the checksum file download.
+func createValidator(ctx context.Context, hashType crypto.Hash, httpClient *http.Client, checksum, filename string, options Options, progress *ProgressBarOptions, seed hash.Hash, pooled bool) (checksumValidator, error) {
 	if len(checksum) == 0 {
 		return &noopValidator{}, nil
 	}
-	var hasher hash.Hash
-	switch hashType {
-	case crypto.SHA256, 0:
-		hasher = sha256.New()
-	case crypto.SHA1:
-		hasher = sha1.New()
-	case crypto.SHA512:
-		hasher = sha512.New()
-	case crypto.MD5:
-		hasher = md5.New() // #nosec
+	var (
+		hasher hash.Hash
+		err    error
+	)
+	switch {
+	case seed != nil:
+		hasher, err = cloneHasher(seed, hashType)
+	case pooled:
+		hasher, err = getHasher(hashType)
 	default:
-		return nil, errors.New("invalid hash function")
+		hasher, err = newHasher(hashType)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	validator, err := newValidator(hasher, httpClient, checksum, filename)
+	cv, err := newValidator(ctx, hasher, httpClient, checksum, filename, options, progress)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create validator")
 	}
+	if v, ok := cv.(*validator); ok {
+		v.hashType = hashType
+		if hashType == 0 {
+			if err = inferValidatorHash(v, seed != nil, pooled); err != nil {
+				return nil, errors.Wrap(err, "failed to create validator")
+			}
+		}
+		if seed != nil {
+			// The number of bytes consumed by the seed is unknown.
+			v.size = -1
+		}
+	}
 
-	return validator, nil
+	return cv, nil
 }
 
+// inferValidatorHash replaces the default SHA256 hasher of v if the length of its checksum,
+// e.g. read from a checksum file, is that of another hash function. A seeded hasher can't be
+// replaced.
+func inferValidatorHash(v *validator, seeded, pooled bool) error {
+	hashType, err := inferHashType(v.checksum)
+	if err != nil || hashType == crypto.SHA256 {
+		return err
+	}
+	if seeded {
+		return errors.Errorf("checksum %s is not a SHA256 checksum; specify ChecksumHash explicitly", v.checksum)
+	}
+	hasher, err := newHasher(hashType)
+	if err != nil {
+		return err
+	}
+	if pooled {
+		putHasher(v.hashType, v.hasher)
+	}
+	v.hasher, v.hashType = hasher, hashType
+	return nil
+}
+
+func newHasher(hashType crypto.Hash) (hash.Hash, error) {
+	switch {
+	case hashType == 0:
+		return sha256.New(), nil
+	case hashType.Available():
+		return hashType.New(), nil
+	case isKnownHash(hashType):
+		return nil, errors.Errorf("hash %s is not available; import its package", hashType)
+	default:
+		return nil, errors.New("invalid hash function")
+	}
+}
+
+// isKnownHash returns true if hashType is one of the hash functions defined by the crypto
+// package, whether or not its implementation is linked into the binary.
+func isKnownHash(hashType crypto.Hash) bool {
+	return hashType > 0 && !strings.HasPrefix(hashType.String(), "unknown hash value")
+}
+
+// acceptStatus returns true if statusCode is accepted by `Options.AcceptStatus`.
+func acceptStatus(statusCode int, options Options) bool {
+	if options.AcceptStatus != nil {
+		return options.AcceptStatus(statusCode)
+	}
+	return statusCode == http.StatusOK
+}
+
+// contentLength returns the Content-Length of resp, falling back to `Options.ExpectedSize`.
+func contentLength(resp *http.Response, options Options) int64 {
+	if resp.ContentLength < 0 && options.ExpectedSize > 0 {
+		return options.ExpectedSize
+	}
+	return resp.ContentLength
+}
+
+func getRetries(options Options) int {
+	if options.Retries == 0 {
+		return 5
+	}
+	return options.Retries
+}
+
+// getHTTPClient returns a copy of the configured client with the redirect policy applied,
+// leaving the caller's client untouched.
 func getHTTPClient(options Options) *http.Client {
 	httpClient := options.HTTPClient
 	if httpClient == nil {
 		httpClient = http.DefaultClient
 	}
-	return httpClient
+	client := *httpClient
+	client.CheckRedirect = checkRedirect(httpClient.CheckRedirect, options.MaxRedirects)
+	client.Transport = pooledTransport(client.Transport, options)
+	if hasRegisteredSchemes() {
+		next := client.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		client.Transport = &schemeTransport{next: next}
+	}
+	return &client
 }
 
 func getBarWriter(w io.Writer) io.Writer {
@@ -319,6 +1549,31 @@ func getBarWriter(w io.Writer) io.Writer {
 	return w
 }
 
+// retryBar is a progress bar shared by all attempts of a download, so that restarting the
+// download resets the same bar rather than leaving an abandoned bar behind.
+type retryBar struct {
+	bar *pb.ProgressBar
+}
+
+// start starts the bar, or restarts it for a new attempt, at current out of length bytes.
+func (r *retryBar) start(length, current int64, options *ProgressBarOptions) *pb.ProgressBar {
+	if r.bar == nil {
+		r.bar = newProgressBar(length, options.MaxWidth, options.Writer)
+		r.bar.Set64(current)
+		r.bar.Start()
+		return r.bar
+	}
+	r.bar.SetTotal64(length)
+	r.bar.Set64(current)
+	return r.bar
+}
+
+func (r *retryBar) finish() {
+	if r.bar != nil {
+		r.bar.Finish()
+	}
+}
+
 func newProgressBar(length int64, maxWidth int, w io.Writer) *pb.ProgressBar {
 	bar := pb.New64(length).SetUnits(pb.U_BYTES)
 	if maxWidth > 0 {
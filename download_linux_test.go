@@ -17,6 +17,9 @@
 package download_test
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -34,6 +37,9 @@ func TestNonWritableDestDirCreateSubdir(t *testing.T) {
 	if !strings.Contains(err.Error(), "failed to create destination directory") {
 		t.Fatalf("unexpected error, expected to contain: '%s', actual: '%v'", "failed to create destination directory", err)
 	}
+	if !errors.Is(err, download.ErrDestinationNotWritable) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrDestinationNotWritable, err)
+	}
 }
 
 func TestNonWritableDestDir(t *testing.T) {
@@ -45,6 +51,40 @@ func TestNonWritableDestDir(t *testing.T) {
 	if !strings.Contains(err.Error(), "failed to create temp file") {
 		t.Fatalf("unexpected error, expected to contain: '%s', actual: '%v'", "failed to create temp file", err)
 	}
+	if !errors.Is(err, download.ErrDestinationNotWritable) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrDestinationNotWritable, err)
+	}
+}
+
+func TestDownloadToFileModes(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "bin", "testfile")
+	err := download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+		FileMode: 0755,
+		DirMode:  0750,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fi, err := os.Stat(tmpFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Mode().Perm() != 0755 {
+		t.Fatalf("wrong file mode, expected: %v, actual: %v", os.FileMode(0755), fi.Mode().Perm())
+	}
+	fi, err = os.Stat(filepath.Dir(tmpFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Mode().Perm()&^0750 != 0 {
+		t.Fatalf("wrong directory mode, expected at most: %v, actual: %v", os.FileMode(0750), fi.Mode().Perm())
+	}
 }
 
 // func TestNonWritableDestFile(t *testing.T) {
@@ -16,14 +16,25 @@ package download_test
 
 import (
 	"bytes"
+	"context"
 	"crypto"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	download "github.com/jimmidyson/go-download"
 )
@@ -213,6 +224,37 @@ func TestDownloadToFile404(t *testing.T) {
 	if !strings.Contains(err.Error(), "received invalid status code") {
 		t.Fatalf("unexpected error, expected to contain: '%s', actual: '%v'", "received invalid status code", err)
 	}
+	var statusErr *download.StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("unexpected error, expected a StatusError, actual: '%v'", err)
+	}
+	if statusErr.StatusCode != http.StatusNotFound || statusErr.URL.Path != "/invalidfile" {
+		t.Fatalf("unexpected status error: %d %s", statusErr.StatusCode, statusErr.URL)
+	}
+}
+
+func TestDownloadToWriterStructuredErrors(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{
+		Checksum: "0000000000000000000000000000000000000000000000000000000000000000",
+	})
+	var mismatch *download.ChecksumMismatchError
+	if !errors.Is(err, download.ErrChecksumMismatch) || !errors.As(err, &mismatch) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrChecksumMismatch, err)
+	}
+	expected := []string{"0000000000000000000000000000000000000000000000000000000000000000"}
+	if fmt.Sprint(mismatch.Expected) != fmt.Sprint(expected) || mismatch.Actual != "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95" {
+		t.Fatalf("unexpected checksum mismatch: %+v", mismatch)
+	}
+
+	err = download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{
+		Checksum: "not a checksum",
+	})
+	if !errors.Is(err, download.ErrChecksumFormat) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrChecksumFormat, err)
+	}
 }
 
 func TestDownloadToFileInvalidChecksumHash(t *testing.T) {
@@ -235,7 +277,7 @@ func TestDownloadToFileInvalidChecksumHash(t *testing.T) {
 	err = download.ToFile(srv.URL+"/testfile", tmpFile.Name(), download.FileOptions{
 		Options: download.Options{
 			Checksum:     "d577273f",
-			ChecksumHash: crypto.SHA224,
+			ChecksumHash: crypto.Hash(999),
 		},
 	})
 	if err == nil {
@@ -246,6 +288,243 @@ func TestDownloadToFileInvalidChecksumHash(t *testing.T) {
 	}
 }
 
+func TestDownloadToWriterChecksums(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		checksum  string
+		checksums []download.ChecksumSpec
+		wantErr   string
+	}{
+		{"all match", "", []download.ChecksumSpec{
+			{Hash: crypto.MD5, Checksum: "d577273ff885c3f84dadb8578bb41399"},
+			{Hash: crypto.SHA256, Checksum: srv.URL + "/CHECKSUMS.sha256"},
+		}, ""},
+		{"with checksum", "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95", []download.ChecksumSpec{
+			{Hash: crypto.SHA1, Checksum: "2672275fe0c456fb671e4f417fb2f9892c7573ba"},
+		}, ""},
+		{"md5 mismatch", "", []download.ChecksumSpec{
+			{Hash: crypto.SHA256, Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95"},
+			{Hash: crypto.MD5, Checksum: "00000000000000000000000000000000"},
+		}, "MD5 checksum"},
+	}
+	for _, tt := range tests {
+		result, err := download.ToWriterWithResult(srv.URL+"/testfile", ioutil.Discard, download.Options{
+			Checksum:  tt.checksum,
+			Checksums: tt.checksums,
+		})
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.name, err)
+			}
+			if !result.ChecksumVerified {
+				t.Fatalf("%s: expected checksum to be verified", tt.name)
+			}
+			continue
+		}
+		if !errors.Is(err, download.ErrChecksumMismatch) || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Fatalf("%s: expected checksum mismatch naming '%s', actual: %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func TestDownloadToWriterGenericChecksumHash(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	result, err := download.ToWriterWithResult(srv.URL+"/testfile", ioutil.Discard, download.Options{
+		Checksum:     "ea2fa9708c96b4acb281be31fa98827addc5017305b7a038a3fca413",
+		ChecksumHash: crypto.SHA224,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.ChecksumVerified {
+		t.Fatal("expected checksum to be verified")
+	}
+}
+
+func TestDownloadToWriterInferChecksumHash(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	for _, checksum := range []string{
+		"d577273ff885c3f84dadb8578bb41399",
+		"2672275fe0c456fb671e4f417fb2f9892c7573ba",
+		srv.URL + "/CHECKSUMS.md5",
+		srv.URL + "/testfile.sha1",
+		srv.URL + "/CHECKSUMS.sha512",
+	} {
+		result, err := download.ToWriterWithResult(srv.URL+"/testfile", ioutil.Discard, download.Options{
+			Checksum: checksum,
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", checksum, err)
+		}
+		if !result.ChecksumVerified {
+			t.Fatalf("%s: expected checksum to be verified", checksum)
+		}
+	}
+
+	err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{
+		Checksum: "d577273ff885c3f84dadb8578bb413",
+	})
+	if !errors.Is(err, download.ErrChecksumFormat) || !strings.Contains(err.Error(), "specify ChecksumHash explicitly") {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrChecksumFormat, err)
+	}
+}
+
+func TestDownloadComputeChecksum(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	sha256sum := "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95"
+	sha512sum := "f2dc0119c9dac46f49d3b7d0be1f61adf7619b770ff076fb11a2f61ff3fcba6b68d224588c4983670da31b33b4efabd448e38a2fda508622cc33ff8304ddf49c"
+	for _, tc := range []struct {
+		options  download.Options
+		checksum string
+		hash     crypto.Hash
+		verified bool
+	}{
+		{download.Options{}, sha256sum, crypto.SHA256, false},
+		{download.Options{ChecksumHash: crypto.SHA512}, sha512sum, crypto.SHA512, false},
+		{download.Options{Checksum: sha256sum}, sha256sum, crypto.SHA256, true},
+		{download.Options{Checksum: srv.URL + "/CHECKSUMS.md5"}, "d577273ff885c3f84dadb8578bb41399", crypto.MD5, true},
+		{download.Options{Checksums: []download.ChecksumSpec{{Hash: crypto.MD5, Checksum: "d577273ff885c3f84dadb8578bb41399"}, {Checksum: sha256sum}}}, "d577273ff885c3f84dadb8578bb41399", crypto.MD5, true},
+		{download.Options{Checksum: sha256sum, ChecksumHash: crypto.SHA256, BufferSize: 4}, sha256sum, crypto.SHA256, true},
+	} {
+		tc.options.ComputeChecksum = true
+		result, err := download.ToWriterWithResult(srv.URL+"/testfile", ioutil.Discard, tc.options)
+		if err != nil {
+			t.Fatalf("%+v: unexpected error: %v", tc.options, err)
+		}
+		if result.Checksum != tc.checksum || result.ChecksumHash != tc.hash {
+			t.Fatalf("%+v: unexpected checksum, expected: %s %s, actual: %s %s", tc.options, tc.hash, tc.checksum, result.ChecksumHash, result.Checksum)
+		}
+		if result.ChecksumVerified != tc.verified {
+			t.Fatalf("%+v: unexpected ChecksumVerified, expected: %v, actual: %v", tc.options, tc.verified, result.ChecksumVerified)
+		}
+	}
+
+	result, err := download.ToWriterWithResult(srv.URL+"/testfile", ioutil.Discard, download.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Checksum != "" {
+		t.Fatalf("expected no checksum to be computed, got %s", result.Checksum)
+	}
+
+	targetDir := filepath.Join("testdata", "output")
+	err = os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	dest := filepath.Join(targetDir, "testfile")
+	err = ioutil.WriteFile(download.PartFileName(dest), []byte("123"), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, options := range []download.FileOptions{
+		{Options: download.Options{ComputeChecksum: true}, Resume: true},
+		{Options: download.Options{ComputeChecksum: true, Concurrency: 2}},
+	} {
+		result, err = download.ToFileWithResult(srv.URL+"/testfile", dest, options)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Checksum != sha256sum {
+			t.Fatalf("unexpected checksum, expected: %s, actual: %s", sha256sum, result.Checksum)
+		}
+	}
+}
+
+func TestDownloadConditional(t *testing.T) {
+	lastModified := time.Date(2016, time.November, 1, 0, 0, 0, 0, time.UTC)
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, req, "testfile", lastModified, strings.NewReader("12345\n"))
+	}))
+	defer srv.Close()
+
+	// A checksum file that doesn't exist fails the download if it is fetched.
+	checksum := srv.URL + "/missing.sha256"
+	var buf bytes.Buffer
+	result, err := download.ToWriterWithResult(srv.URL+"/testfile", &buf, download.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.ETag != `"v1"` || !result.LastModified.Equal(lastModified) {
+		t.Fatalf("unexpected validators, ETag: %s, Last-Modified: %v", result.ETag, result.LastModified)
+	}
+
+	for _, conditional := range []download.Options{
+		{IfNoneMatch: result.ETag},
+		{IfModifiedSince: result.LastModified},
+	} {
+		conditional.Checksum = checksum
+		buf.Reset()
+		err = download.ToWriter(srv.URL+"/testfile", &buf, conditional)
+		if !errors.Is(err, download.ErrNotModified) {
+			t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrNotModified, err)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("expected nothing to be written, got: '%s'", buf.String())
+		}
+	}
+
+	buf.Reset()
+	err = download.ToWriter(srv.URL+"/testfile", &buf, download.Options{IfNoneMatch: `"v0"`})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "12345\n" {
+		t.Fatalf("unexpected contents: '%s'", buf.String())
+	}
+
+	targetDir := filepath.Join("testdata", "output")
+	err = os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	dest := filepath.Join(targetDir, "testfile")
+	atomic.StoreInt32(&requests, 0)
+	_, err = download.ToFileMulti([]string{srv.URL + "/testfile", srv.URL + "/mirror"}, dest, download.FileOptions{
+		Options: download.Options{IfNoneMatch: `"v1"`, Concurrency: 2},
+	})
+	if !errors.Is(err, download.ErrNotModified) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrNotModified, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected a single request, got %d", n)
+	}
+	if _, err = os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("expected file to not have been created, got: %v", err)
+	}
+}
+
+func TestDownloadToFileUnavailableChecksumHash(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{
+		Checksum:     "d577273f",
+		ChecksumHash: crypto.RIPEMD160,
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	expected := "hash RIPEMD-160 is not available; import its package"
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("unexpected error, expected to contain: '%s', actual: '%v'", expected, err)
+	}
+}
+
 type checksum struct {
 	checksumFile string
 	hash         crypto.Hash
@@ -260,6 +539,12 @@ var checksumTests = []checksum{
 	{"CHECKSUMS.sha256", crypto.SHA256},
 	{"testfile.sha512", crypto.SHA512},
 	{"CHECKSUMS.sha512", crypto.SHA512},
+	{"testfile.sha3-256", crypto.SHA3_256},
+	{"CHECKSUMS.sha3-256", crypto.SHA3_256},
+	{"testfile.sha3-512", crypto.SHA3_512},
+	{"CHECKSUMS.sha3-512", crypto.SHA3_512},
+	{"testfile.blake2b-512", crypto.BLAKE2b_512},
+	{"CHECKSUMS.blake2b-512", crypto.BLAKE2b_512},
 }
 
 func TestDownloadToFileWithChecksumValidation(t *testing.T) {
@@ -311,6 +596,30 @@ func TestDownloadToFileWithChecksumValidation(t *testing.T) {
 	}
 }
 
+func TestDownloadToWriterUpperCaseChecksum(t *testing.T) {
+	const digest = "F33AE3BC9A22CD7564990A794789954409977013966FB1A8F43C35776B833A95"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/testfile.sha256" {
+			_, _ = w.Write([]byte(digest + "  testfile\n"))
+			return
+		}
+		http.ServeFile(w, req, filepath.Join("testdata", "testfile"))
+	}))
+	defer srv.Close()
+
+	for _, checksum := range []string{digest, srv.URL + "/testfile.sha256"} {
+		result, err := download.ToWriterWithResult(srv.URL+"/testfile", ioutil.Discard, download.Options{
+			Checksum: checksum,
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", checksum, err)
+		}
+		if !result.ChecksumVerified {
+			t.Fatalf("%s: expected checksum to be verified", checksum)
+		}
+	}
+}
+
 func TestInvalidURL(t *testing.T) {
 	err := download.ToFile("://invalid", "", download.FileOptions{})
 	if err == nil {
@@ -329,6 +638,9 @@ func TestNonExistentDestDir(t *testing.T) {
 	if !strings.Contains(err.Error(), "directory "+filepath.Join("testdata", "nonexistentdir")+" does not exist") {
 		t.Fatalf("unexpected error, expected to contain: '%s', actual: '%v'", "directory "+filepath.Join("testdata", "nonexistentdir")+" does not exist", err)
 	}
+	if !errors.Is(err, download.ErrDestinationNotWritable) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrDestinationNotWritable, err)
+	}
 }
 
 func TestDownloadToFileSuccessWithRetry(t *testing.T) {
@@ -405,3 +717,3462 @@ func TestDownloadToFileFailure(t *testing.T) {
 		t.Fatal("expected error")
 	}
 }
+
+func TestDownloadToWriterSignRequest(t *testing.T) {
+	hfs := http.FileServer(http.Dir("testdata"))
+	hf := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Signature") != "signed:"+req.URL.Path {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		hfs.ServeHTTP(w, req)
+	})
+	srv := httptest.NewServer(hf)
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	err := download.ToWriter(srv.URL+"/testfile", &buf, download.Options{
+		SignRequest: func(req *http.Request) error {
+			req.Header.Set("X-Signature", "signed:"+req.URL.Path)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(testData, buf.Bytes()) {
+		t.Fatal("wrong downloaded data")
+	}
+}
+
+func TestDownloadToFileHeaders(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers []http.Header
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		headers = append(headers, req.Header.Clone())
+		failed := len(headers) == 1
+		mu.Unlock()
+		if failed {
+			// Cut off the response so that it is retried.
+			w.Header().Set("Content-Range", "bytes 3-5/6")
+			w.Header().Set("Content-Length", "3")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte("4")) // #nosec
+			return
+		}
+		http.ServeFile(w, req, filepath.Join("testdata", "testfile"))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	if err = ioutil.WriteFile(download.PartFileName(tmpFile), []byte("123"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+		Options: download.Options{
+			Headers: http.Header{
+				"Authorization": {"Bearer secret"},
+				"User-Agent":    {"my-agent/1.0"},
+			},
+			RetryInterval: time.Millisecond,
+		},
+		Resume: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(headers) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(headers))
+	}
+	for i, h := range headers {
+		if h.Get("Authorization") != "Bearer secret" || h.Get("User-Agent") != "my-agent/1.0" {
+			t.Fatalf("request %d: unexpected headers: %v", i, h)
+		}
+		if h.Get("Range") != "bytes=3-" {
+			t.Fatalf("request %d: expected Range header to be kept, got: '%s'", i, h.Get("Range"))
+		}
+	}
+}
+
+func TestDownloadToFileResumeContentRangeMismatch(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, req.Header.Get("Range"))
+		mu.Unlock()
+		if req.Header.Get("Range") != "" {
+			// Respond with the whole resource as if it were the requested range.
+			w.Header().Set("Content-Range", "bytes 0-5/6")
+			w.Header().Set("Content-Length", "6")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte("12345\n")) // #nosec
+			return
+		}
+		http.ServeFile(w, req, filepath.Join("testdata", "testfile"))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	if err = ioutil.WriteFile(download.PartFileName(tmpFile), []byte("123"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+		Options: download.Options{
+			Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+		},
+		Resume: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	downloaded, err := ioutil.ReadFile(tmpFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(downloaded) != "12345\n" {
+		t.Fatalf("wrong downloaded data, expected %q, actual %q", "12345\n", downloaded)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 2 || ranges[0] != "bytes=3-" || ranges[1] != "" {
+		t.Fatalf("expected the download to restart without a Range header, got: %v", ranges)
+	}
+}
+
+func TestDownloadToWriterSignRequestFailure(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{
+		SignRequest: func(req *http.Request) error {
+			return errors.New("no credentials")
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "failed to sign request") {
+		t.Fatalf("unexpected error, expected to contain: '%s', actual: '%v'", "failed to sign request", err)
+	}
+}
+
+func truncatedChunkedHandler(w http.ResponseWriter, req *http.Request) {
+	conn, bufrw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	_, _ = bufrw.WriteString("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n5\r\nwo")
+	_ = bufrw.Flush()
+}
+
+func TestDownloadToWriterShortDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(truncatedChunkedHandler))
+	defer srv.Close()
+
+	err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !errors.Is(err, download.ErrShortDownload) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrShortDownload, err)
+	}
+}
+
+func TestDownloadToFileRetryShortDownload(t *testing.T) {
+	hfs := http.FileServer(http.Dir("testdata"))
+	i := 0
+	hf := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if i < 2 {
+			i++
+			truncatedChunkedHandler(w, req)
+			return
+		}
+		hfs.ServeHTTP(w, req)
+	})
+	srv := httptest.NewServer(hf)
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	downloadedData, err := ioutil.ReadFile(tmpFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(testData, downloadedData) {
+		t.Fatal("wrong downloaded data")
+	}
+}
+
+func TestDownloadToFilePostVerify(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+
+	var verified string
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+		PostVerify: func(path string) error {
+			verified = path
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if verified != tmpFile {
+		t.Fatalf("wrong path verified, expected %s, actual %s", tmpFile, verified)
+	}
+
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+		PostVerify: func(path string) error {
+			return errors.New("bad magic")
+		},
+		RemoveOnPostVerifyError: true,
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "post-download verification failed: bad magic") {
+		t.Fatalf("unexpected error, expected to contain: '%s', actual: '%v'", "post-download verification failed: bad magic", err)
+	}
+	if _, err = os.Stat(tmpFile); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed", tmpFile)
+	}
+}
+
+func TestDownloadToFileWriteChecksumFile(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{WriteChecksumFile: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checksumData, err := ioutil.ReadFile(tmpFile + ".sha256")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95  testfile\n"
+	if string(checksumData) != expected {
+		t.Fatalf("wrong checksum file contents, expected '%s', actual '%s'", expected, checksumData)
+	}
+
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+		Options: download.Options{
+			Checksum:     tmpFile + ".sha256",
+			ChecksumHash: crypto.SHA256,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDownloadToFileWriteMultipleChecksumFiles(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+		Options: download.Options{
+			Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+		},
+		WriteChecksumFile:  true,
+		ChecksumFileHashes: []crypto.Hash{crypto.MD5, crypto.SHA1, crypto.SHA256, crypto.SHA512},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, ext := range []string{"md5", "sha1", "sha256", "sha512"} {
+		expected, err := ioutil.ReadFile(filepath.Join("testdata", "testfile."+ext))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		checksumData, err := ioutil.ReadFile(tmpFile + "." + ext)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.HasPrefix(string(checksumData), strings.TrimSpace(string(expected))+"  testfile") {
+			t.Fatalf("wrong %s checksum file contents, expected digest '%s', actual '%s'", ext, expected, checksumData)
+		}
+	}
+}
+
+func TestDownloadToFileWriteChecksumFileUnsupportedHash(t *testing.T) {
+	var requests int32
+	hfs := http.FileServer(http.Dir("testdata"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		hfs.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	dest := filepath.Join(targetDir, "testfile")
+
+	err := download.ToFile(srv.URL+"/testfile", dest, download.FileOptions{
+		WriteChecksumFile:  true,
+		ChecksumFileHashes: []crypto.Hash{crypto.SHA256, crypto.MD4},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("expected no requests, got %d", n)
+	}
+	if _, err = os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("expected %s not to exist, got: %v", dest, err)
+	}
+}
+
+func TestDownloadToFileDirectWrite(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	err = ioutil.WriteFile(tmpFile, []byte("some much longer existing content"), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{DirectWrite: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	downloadedData, err := ioutil.ReadFile(tmpFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(testData, downloadedData) {
+		t.Fatal("wrong downloaded data")
+	}
+
+	files, err := ioutil.ReadDir(targetDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected only the destination file to be written, found %d files", len(files))
+	}
+
+	err = download.ToFile(srv.URL+"/invalidfile", tmpFile, download.FileOptions{DirectWrite: true})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err = os.Stat(tmpFile); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed", tmpFile)
+	}
+}
+
+func TestDownloadToWriterAccept(t *testing.T) {
+	hf := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(req.Header.Get("Accept")))
+	})
+	srv := httptest.NewServer(hf)
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	err := download.ToWriter(srv.URL+"/manifest", &buf, download.Options{
+		Accept: "application/vnd.oci.image.manifest.v1+json",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "application/vnd.oci.image.manifest.v1+json" {
+		t.Fatalf("wrong Accept header, expected '%s', actual '%s'", "application/vnd.oci.image.manifest.v1+json", buf.String())
+	}
+}
+
+func TestDestFileParentIsFile(t *testing.T) {
+	err := download.ToFile("http://doesnotmatter", filepath.Join("testdata", "testfile", "somewhere"), download.FileOptions{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !errors.Is(err, download.ErrDestinationNotWritable) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrDestinationNotWritable, err)
+	}
+}
+
+func TestDownloadToWriterVars(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	err := download.ToWriter("{server}/{file}", &buf, download.Options{
+		Vars: map[string]string{
+			"server": srv.URL,
+			"file":   "testfile",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(testData, buf.Bytes()) {
+		t.Fatal("wrong downloaded data")
+	}
+
+	err = download.ToWriter("{server}/{version}/{file}", &buf, download.Options{
+		Vars: map[string]string{
+			"server": srv.URL,
+			"file":   "testfile",
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "no value for variables [version]") {
+		t.Fatalf("unexpected error, expected to contain: '%s', actual: '%v'", "no value for variables [version]", err)
+	}
+}
+
+func TestDownloadToWriterRedirectToUnsupportedScheme(t *testing.T) {
+	requests := 0
+	hf := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		http.Redirect(w, req, "file:///etc/passwd", http.StatusFound)
+	})
+	srv := httptest.NewServer(hf)
+	defer srv.Close()
+
+	err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !errors.Is(err, download.ErrDisallowedRedirect) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrDisallowedRedirect, err)
+	}
+	if requests != 1 {
+		t.Fatalf("expected disallowed redirect not to be retried, received %d requests", requests)
+	}
+}
+
+func TestDownloadToFileOffline(t *testing.T) {
+	requests := 0
+	hf := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(hf)
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+
+	offline := func(checksum string) error {
+		return download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+			Options: download.Options{
+				Checksum: checksum,
+				Offline:  true,
+			},
+		})
+	}
+
+	if err = offline(""); !errors.Is(err, download.ErrOffline) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrOffline, err)
+	}
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = ioutil.WriteFile(tmpFile, testData, 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = offline("f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = offline(filepath.Join("testdata", "CHECKSUMS.sha256")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = offline("0000000000000000000000000000000000000000000000000000000000000000"); !errors.Is(err, download.ErrOffline) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrOffline, err)
+	}
+	if err = offline(srv.URL + "/testfile.sha256"); !errors.Is(err, download.ErrOffline) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrOffline, err)
+	}
+
+	if requests != 0 {
+		t.Fatalf("expected no requests in offline mode, received %d", requests)
+	}
+}
+
+func TestDownloadToFileOfflineDecompressed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tmpFile := filepath.Join(targetDir, "testfile")
+	if err = ioutil.WriteFile(tmpFile, testData, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	offline := func(checksum string, checksumOfDecompressed bool) error {
+		return download.ToFile(srv.URL+"/testfile.gz", tmpFile, download.FileOptions{
+			Options: download.Options{
+				Checksum: checksum,
+				Offline:  true,
+			},
+			Decompress:             download.DecompressGzip,
+			ChecksumOfDecompressed: checksumOfDecompressed,
+		})
+	}
+
+	// The checksum file is looked up under the decompressed name.
+	if err = offline(filepath.Join("testdata", "CHECKSUMS.sha256"), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = offline("f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95", true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = offline("", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = offline("f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95", false)
+	if err == nil || !strings.Contains(err.Error(), "requires ChecksumOfDecompressed") {
+		t.Fatalf("expected ChecksumOfDecompressed to be required, got: %v", err)
+	}
+}
+
+func TestDownloadToFileTempFileFunc(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	stagingDir := filepath.Join(targetDir, "staging")
+	err := os.MkdirAll(stagingDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+
+	var tempPath string
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+		TempFileFunc: func(dir, base string) (*os.File, error) {
+			if dir != targetDir || base != "testfile" {
+				return nil, fmt.Errorf("unexpected dir %s and base %s", dir, base)
+			}
+			f, err := os.Create(filepath.Join(stagingDir, base+".partial"))
+			if err == nil {
+				tempPath = f.Name()
+			}
+			return f, err
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tempPath != filepath.Join(stagingDir, "testfile.partial") {
+		t.Fatalf("wrong temp file used: %s", tempPath)
+	}
+	if _, err = os.Stat(tempPath); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be renamed", tempPath)
+	}
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	downloadedData, err := ioutil.ReadFile(tmpFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(testData, downloadedData) {
+		t.Fatal("wrong downloaded data")
+	}
+}
+
+func TestDownloadToWriterChecksumVerified(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	result, err := download.ToWriterWithResult(srv.URL+"/testfile", &buf, download.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.ChecksumVerified {
+		t.Fatal("expected checksum not to be verified")
+	}
+
+	buf.Reset()
+	result, err = download.ToWriterWithResult(srv.URL+"/testfile", &buf, download.Options{
+		Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.ChecksumVerified {
+		t.Fatal("expected checksum to be verified")
+	}
+}
+
+func TestDownloadToFileStrictChecksum(t *testing.T) {
+	var requests int
+	hfs := http.FileServer(http.Dir("testdata"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		hfs.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	dest := filepath.Join(targetDir, "testfile")
+
+	for _, checksum := range []string{"", "  "} {
+		_, err := download.ToFileWithResult(srv.URL+"/testfile", dest, download.FileOptions{
+			Options: download.Options{
+				Checksum:       checksum,
+				StrictChecksum: true,
+			},
+		})
+		if err == nil {
+			t.Fatalf("expected error for checksum '%s'", checksum)
+		}
+	}
+	if requests != 0 {
+		t.Fatalf("expected no requests, actual %d", requests)
+	}
+
+	result, err := download.ToFileWithResult(srv.URL+"/testfile", dest, download.FileOptions{
+		Options: download.Options{
+			Checksum:       srv.URL + "/testfile.sha256",
+			StrictChecksum: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.ChecksumVerified {
+		t.Fatal("expected checksum to be verified")
+	}
+}
+
+func TestDownloadToWriterURLExpired(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{
+		URLExpiry: time.Now().Add(-time.Minute),
+	})
+	if !errors.Is(err, download.ErrURLExpired) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrURLExpired, err)
+	}
+	if requests != 0 {
+		t.Fatalf("expected no requests, actual %d", requests)
+	}
+}
+
+func TestDownloadToFileURLRefresh(t *testing.T) {
+	hfs := http.FileServer(http.Dir("testdata"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("sig") != "fresh" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		hfs.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tests := []struct {
+		name   string
+		expiry time.Time
+	}{
+		{"retry", time.Time{}},
+		{"expiring", time.Now().Add(time.Second)},
+	}
+	for _, tt := range tests {
+		var refreshes int
+		err := download.ToFile(srv.URL+"/testfile?sig=stale", filepath.Join(targetDir, tt.name), download.FileOptions{
+			Options: download.Options{
+				URLExpiry: tt.expiry,
+				URLRefresh: func() (string, error) {
+					refreshes++
+					return srv.URL + "/testfile?sig=fresh", nil
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if refreshes != 1 {
+			t.Fatalf("%s: wrong number of refreshes, expected %d, actual %d", tt.name, 1, refreshes)
+		}
+	}
+}
+
+func TestDownloadToWriterChecksumProgressBar(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{
+		Checksum:     srv.URL + "/CHECKSUMS.sha256",
+		ProgressBars: &download.ProgressBarOptions{Writer: &out},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "checksum ") {
+		t.Fatalf("expected progress output to contain: '%s', actual: '%s'", "checksum ", out.String())
+	}
+}
+
+func TestDownloadToWriterProgressFunc(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadFile(filepath.Join("testdata", "testfile")) // #nosec
+		if req.URL.Path != "/chunked" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		}
+		// Flushing before writing omits the Content-Length if it isn't set.
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(b) // #nosec
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path  string
+		total int64
+	}{
+		{"/testfile", 6},
+		{"/chunked", -1},
+	}
+	for _, tt := range tests {
+		type call struct{ downloaded, total int64 }
+		var calls []call
+		var out bytes.Buffer
+		err := download.ToWriter(srv.URL+tt.path, ioutil.Discard, download.Options{
+			ProgressBars: &download.ProgressBarOptions{Writer: &out},
+			ProgressFunc: func(downloaded, total int64) {
+				calls = append(calls, call{downloaded, total})
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.path, err)
+		}
+		if len(calls) < 2 {
+			t.Fatalf("%s: expected at least 2 calls, got: %v", tt.path, calls)
+		}
+		if calls[0].total != tt.total {
+			t.Fatalf("%s: wrong total, expected %d, actual %d", tt.path, tt.total, calls[0].total)
+		}
+		if last := calls[len(calls)-1]; last != (call{6, 6}) {
+			t.Fatalf("%s: wrong final call: %v", tt.path, last)
+		}
+	}
+}
+
+func TestDownloadToWriterMaxBytesPerSecond(t *testing.T) {
+	testData := bytes.Repeat([]byte("x"), 1500)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write(testData) // #nosec
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	start := time.Now()
+	err := download.ToWriter(srv.URL, &buf, download.Options{MaxBytesPerSecond: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Fatalf("expected download to take at least 1.5s, took %v", elapsed)
+	}
+	if !bytes.Equal(buf.Bytes(), testData) {
+		t.Fatalf("unexpected download of %d bytes", buf.Len())
+	}
+}
+
+// bogusLengthTransport serves responses whose Content-Length exceeds their body.
+type bogusLengthTransport struct{}
+
+func (bogusLengthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{},
+		ContentLength: 10,
+		Body:          ioutil.NopCloser(strings.NewReader("12345\n")),
+		Request:       req,
+	}, nil
+}
+
+func TestDownloadToWriterVerifyContentLength(t *testing.T) {
+	client := &http.Client{Transport: bogusLengthTransport{}}
+	err := download.ToWriter("http://example.com/testfile", ioutil.Discard, download.Options{
+		HTTPClient: client,
+		Checksum:   "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+	})
+	if !errors.Is(err, download.ErrSizeMismatch) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrSizeMismatch, err)
+	}
+
+	disabled := false
+	err = download.ToWriter("http://example.com/testfile", ioutil.Discard, download.Options{
+		HTTPClient:          client,
+		Checksum:            "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+		VerifyContentLength: &disabled,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDownloadToWriterVerifyFromFilename(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.ServeFile(w, req, filepath.Join("testdata", "testfile"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"testfile-f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95.txt", false},
+		{"d577273ff885c3f84dadb8578bb41399_testfile", false},
+		{"D577273FF885C3F84DADB8578BB41399_testfile", false},
+		{"testfile-0000000000000000000000000000000000000000000000000000000000000000.txt", true},
+		{"testfile", true},
+	}
+	for _, tt := range tests {
+		result, err := download.ToWriterWithResult(srv.URL+"/"+tt.name, ioutil.Discard, download.Options{
+			VerifyFromFilename: true,
+		})
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("%s: expected error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !result.ChecksumVerified {
+			t.Fatalf("%s: expected checksum to be verified", tt.name)
+		}
+	}
+}
+
+func TestDownloadToWriterMaxRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var n int
+		if _, err := fmt.Sscanf(req.URL.Path, "/redirect/%d", &n); err != nil || n == 0 {
+			http.ServeFile(w, req, filepath.Join("testdata", "testfile"))
+			return
+		}
+		http.Redirect(w, req, fmt.Sprintf("/redirect/%d", n-1), http.StatusFound)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		maxRedirects int
+		redirects    int
+		wantErr      bool
+	}{
+		{0, 9, false},
+		{0, 10, true},
+		{3, 3, false},
+		{3, 4, true},
+		{-1, 0, false},
+		{-1, 1, true},
+	}
+	for _, tt := range tests {
+		err := download.ToWriter(fmt.Sprintf("%s/redirect/%d", srv.URL, tt.redirects), ioutil.Discard, download.Options{
+			MaxRedirects: tt.maxRedirects,
+		})
+		if tt.wantErr {
+			if !errors.Is(err, download.ErrTooManyRedirects) {
+				t.Fatalf("max %d, redirects %d: unexpected error, expected: '%v', actual: '%v'", tt.maxRedirects, tt.redirects, download.ErrTooManyRedirects, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("max %d, redirects %d: unexpected error: %v", tt.maxRedirects, tt.redirects, err)
+		}
+	}
+}
+
+func TestDownloadToFilePromote(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	dest := filepath.Join(targetDir, "testfile")
+	store := filepath.Join(targetDir, "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95")
+
+	var promotedFrom string
+	err := download.ToFile(srv.URL+"/testfile", dest, download.FileOptions{
+		Promote: func(tempPath, dest string) error {
+			promotedFrom = tempPath
+			if err := os.Link(tempPath, store); err != nil {
+				return err
+			}
+			return os.Link(tempPath, dest)
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, f := range []string{dest, store} {
+		downloadedData, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(testData, downloadedData) {
+			t.Fatalf("wrong data in %s", f)
+		}
+	}
+	if _, err = os.Stat(promotedFrom); !os.IsNotExist(err) {
+		t.Fatalf("expected temp file %s to be removed", promotedFrom)
+	}
+
+	promoteErr := fmt.Errorf("promote failed")
+	err = download.ToFile(srv.URL+"/testfile", filepath.Join(targetDir, "failed"), download.FileOptions{
+		Promote: func(tempPath, dest string) error {
+			return promoteErr
+		},
+	})
+	if !errors.Is(err, promoteErr) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", promoteErr, err)
+	}
+}
+
+func TestDownloadConcurrentSharedOptions(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	options := download.FileOptions{
+		Options: download.Options{
+			HTTPClient: &http.Client{
+				Transport: &http.Transport{},
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return nil
+				},
+			},
+			Checksum:     srv.URL + "/CHECKSUMS.sha256",
+			Vars:         map[string]string{"file": "testfile"},
+			MaxRedirects: 3,
+		},
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			errs <- download.ToFile(srv.URL+"/{file}", filepath.Join(targetDir, fmt.Sprintf("testfile%d", i)), options)
+		}(i)
+		go func() {
+			defer wg.Done()
+			errs <- download.ToWriter(srv.URL+"/{file}", ioutil.Discard, options.Options)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestChecksumFileRoundTrip(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	name := download.ChecksumFileName(filepath.Join(targetDir, "testfile"), crypto.MD5)
+	if filepath.Base(name) != "testfile.md5" {
+		t.Fatalf("wrong checksum file name, expected %s, actual %s", "testfile.md5", filepath.Base(name))
+	}
+	line := download.FormatChecksumLine(crypto.MD5, "D577273FF885C3F84DADB8578BB41399", "testfile")
+	if err = ioutil.WriteFile(name, []byte(line), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{
+		Checksum:     name,
+		ChecksumHash: crypto.MD5,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Every supported hash verifies the checksum files written for it.
+	hashes := []crypto.Hash{
+		crypto.MD5, crypto.SHA1, crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512,
+		crypto.SHA3_256, crypto.SHA3_512, crypto.BLAKE2b_512,
+	}
+	dest := filepath.Join(targetDir, "written")
+	err = download.ToFile(srv.URL+"/testfile", dest, download.FileOptions{
+		WriteChecksumFile:  true,
+		ChecksumFileHashes: hashes,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, hashType := range hashes {
+		err = download.ToWriter(srv.URL+"/output/written", ioutil.Discard, download.Options{
+			Checksum:     download.ChecksumFileName(dest, hashType),
+			ChecksumHash: hashType,
+		})
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", hashType, err)
+		}
+	}
+	if filepath.Base(download.ChecksumFileName(dest, crypto.SHA384)) != "written.sha384" {
+		t.Fatalf("wrong checksum file name, expected %s, actual %s", "written.sha384", download.ChecksumFileName(dest, crypto.SHA384))
+	}
+}
+
+func BenchmarkDownloadToFile(b *testing.B) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4<<20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.ServeContent(w, req, "large", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	for _, bm := range []struct {
+		name    string
+		options download.FileOptions
+	}{
+		{"NoChecksum", download.FileOptions{}},
+		{"Checksum", download.FileOptions{Options: download.Options{Checksum: fmt.Sprintf("%x", sha256.Sum256(data))}}},
+		{"ChecksumFile", download.FileOptions{
+			Options:           download.Options{Checksum: fmt.Sprintf("%x", sha256.Sum256(data))},
+			WriteChecksumFile: true,
+		}},
+		{"AllFeatures", download.FileOptions{
+			Options: download.Options{
+				Checksum:       fmt.Sprintf("%x", sha256.Sum256(data)),
+				DiagnosticHash: crypto.MD5,
+				ChunkChecksums: []string{fmt.Sprintf("%x", sha256.Sum256(data))},
+				ChunkSize:      int64(len(data)),
+				MaxBytes:       int64(len(data)),
+				ProgressBars:   &download.ProgressBarOptions{Writer: ioutil.Discard},
+			},
+			WriteChecksumFile: true,
+		}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := download.ToFile(srv.URL+"/large", filepath.Join(targetDir, "large"), bm.options); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadToWriterChecksumSeed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte("45\n"))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	partPath := filepath.Join(targetDir, "testfile.part")
+	h := sha256.New()
+	_, _ = h.Write([]byte("123")) // #nosec
+	if err = download.SaveHashState(partPath, h); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	seed := sha256.New()
+	if err = download.LoadHashState(partPath, seed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	options := download.Options{
+		Checksum:     "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+		ChecksumSeed: seed,
+	}
+	for i := 0; i < 2; i++ {
+		if err = download.ToWriter(srv.URL+"/testfile", ioutil.Discard, options); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	options.ChecksumHash = crypto.MD5
+	if err = download.ToWriter(srv.URL+"/testfile", ioutil.Discard, options); err == nil {
+		t.Fatal("expected error for seed not matching ChecksumHash")
+	}
+}
+
+func TestDownloadFromURLsResult(t *testing.T) {
+	var primaryRequests int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		primaryRequests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer primary.Close()
+	var failing bool
+	hfs := http.FileServer(http.Dir("testdata"))
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if failing {
+			failing = false
+			hj, _ := w.(http.Hijacker)
+			conn, _, _ := hj.Hijack()
+			_ = conn.Close() // #nosec
+			return
+		}
+		hfs.ServeHTTP(w, req)
+	}))
+	defer mirror.Close()
+
+	primaryURL, _ := url.Parse(primary.URL + "/testfile")
+	mirrorURL, _ := url.Parse(mirror.URL + "/testfile")
+
+	failing = true
+	var buf bytes.Buffer
+	result, err := download.FromURLs([]*url.URL{primaryURL, mirrorURL}, &buf, download.Options{
+		Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.MirrorUsed != mirrorURL {
+		t.Fatalf("wrong mirror used, expected %s, actual %s", mirrorURL, result.MirrorUsed)
+	}
+	if !result.ChecksumVerified {
+		t.Fatal("expected checksum to be verified")
+	}
+	if result.Attempts != 3 {
+		t.Fatalf("wrong number of attempts, expected %d, actual %d", 3, result.Attempts)
+	}
+	if len(result.Mirrors) != 2 {
+		t.Fatalf("wrong number of mirrors, expected %d, actual %d", 2, len(result.Mirrors))
+	}
+	if result.Mirrors[0].Attempts != 1 || result.Mirrors[0].Err == nil {
+		t.Fatalf("unexpected primary mirror outcome: %+v", result.Mirrors[0])
+	}
+	if result.Mirrors[1].Attempts != 2 || result.Mirrors[1].Err != nil {
+		t.Fatalf("unexpected mirror outcome: %+v", result.Mirrors[1])
+	}
+
+	_, err = download.FromURLs([]*url.URL{primaryURL}, &buf, download.Options{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDownloadToFileMulti(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	corrupt := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte("54321\n"))
+	}))
+	defer corrupt.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	mirror := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer mirror.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	dest := filepath.Join(targetDir, "testfile")
+	options := download.FileOptions{
+		Options: download.Options{
+			Checksum:      "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+			ChecksumHash:  crypto.SHA256,
+			Retries:       1,
+			RetryInterval: time.Millisecond,
+		},
+	}
+	result, err := download.ToFileMulti([]string{down.URL + "/testfile", corrupt.URL + "/testfile", mirror.URL + "/testfile"}, dest, options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.MirrorUsed.String() != mirror.URL+"/testfile" {
+		t.Fatalf("wrong mirror used, expected %s, actual %s", mirror.URL+"/testfile", result.MirrorUsed)
+	}
+	if len(result.Mirrors) != 3 || result.Mirrors[0].Err == nil || !errors.Is(result.Mirrors[1].Err, download.ErrChecksumMismatch) {
+		t.Fatalf("unexpected mirror outcomes: %+v", result.Mirrors)
+	}
+	total := 0
+	for i, m := range result.Mirrors {
+		if m.Attempts == 0 {
+			t.Fatalf("mirror %d: expected attempts to be recorded, got: %+v", i, m)
+		}
+		total += m.Attempts
+	}
+	if result.Attempts != total {
+		t.Fatalf("wrong total attempts, expected %d, actual %d", total, result.Attempts)
+	}
+	contents, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(contents) != "12345\n" {
+		t.Fatalf("unexpected contents: '%s'", contents)
+	}
+
+	err = os.Remove(dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err = download.ToFileMulti([]string{failing.URL + "/testfile", corrupt.URL + "/testfile"}, dest, options)
+	if len(result.Mirrors) != 2 || result.Mirrors[0].Attempts != 1 || result.Mirrors[1].Attempts != 1 {
+		t.Fatalf("unexpected mirror outcomes: %+v", result.Mirrors)
+	}
+	if !errors.Is(err, download.ErrChecksumMismatch) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrChecksumMismatch, err)
+	}
+	for _, src := range []string{failing.URL, corrupt.URL} {
+		if !strings.Contains(err.Error(), src) {
+			t.Fatalf("expected error to report failure of %s, got: %v", src, err)
+		}
+	}
+	if _, err = os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("expected file to not have been created, got: %v", err)
+	}
+}
+
+func TestDownloadFromMirrorsAcceptStatus(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte("12345\n"))
+	}))
+	defer bucket.Close()
+
+	primaryURL, _ := url.Parse(primary.URL + "/testfile")
+	bucketURL, _ := url.Parse(bucket.URL + "/testfile")
+	options := download.Options{
+		Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+	}
+
+	var buf bytes.Buffer
+	_, err := download.FromURLs([]*url.URL{primaryURL, bucketURL}, &buf, options)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, code := range []string{"503", "206"} {
+		if !strings.Contains(err.Error(), code) {
+			t.Fatalf("expected error to report status code %s, got: %v", code, err)
+		}
+	}
+
+	result, err := download.FromMirrors([]download.Mirror{
+		{URL: primaryURL},
+		{URL: bucketURL, AcceptStatus: func(statusCode int) bool { return statusCode == http.StatusPartialContent }},
+	}, &buf, options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.MirrorUsed != bucketURL {
+		t.Fatalf("wrong mirror used, expected %s, actual %s", bucketURL, result.MirrorUsed)
+	}
+	if buf.String() != "12345\n" {
+		t.Fatalf("wrong downloaded data: %q", buf.String())
+	}
+}
+
+func TestDownloadToWriterChunkChecksums(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	chunks := []string{
+		"03ac674216f3e15c761ee1a5e255f067953623c8b388b4459e13f978d7c846f4",
+		"f0b5c2c2211c8d67ed15e75e656c7862d086e9245420892a7de62cd9ec582a06",
+	}
+	for _, tc := range []struct {
+		chunks []string
+		ok     bool
+	}{
+		{chunks, true},
+		{chunks[:1], false},
+		{append(chunks, chunks[1]), false},
+		{[]string{chunks[1], chunks[0]}, false},
+	} {
+		result, err := download.ToWriterWithResult(srv.URL+"/testfile", ioutil.Discard, download.Options{
+			ChunkChecksums: tc.chunks,
+			ChunkSize:      4,
+		})
+		if !tc.ok {
+			if !errors.Is(err, download.ErrChunkMismatch) {
+				t.Fatalf("%v: unexpected error, expected: '%v', actual: '%v'", tc.chunks, download.ErrChunkMismatch, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tc.chunks, err)
+		}
+		if !result.ChecksumVerified {
+			t.Fatalf("%v: expected checksum to be verified", tc.chunks)
+		}
+	}
+}
+
+func TestDownloadToWriterChunkChecksumsFailFast(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		_, _ = w.Write([]byte("0000"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-done:
+		case <-req.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{
+			ChunkChecksums: []string{"03ac674216f3e15c761ee1a5e255f067953623c8b388b4459e13f978d7c846f4"},
+			ChunkSize:      4,
+		})
+	}()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, download.ErrChunkMismatch) {
+			t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrChunkMismatch, err)
+		}
+		if !strings.Contains(err.Error(), "chunk 0") {
+			t.Fatalf("expected error to contain: '%s', actual: '%v'", "chunk 0", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for chunk mismatch")
+	}
+}
+
+func TestDownloadIfChanged(t *testing.T) {
+	lastModified := time.Date(2016, time.October, 1, 12, 0, 0, 0, time.UTC)
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		http.ServeContent(w, req, "testfile", lastModified, strings.NewReader("12345\n"))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	dest := filepath.Join(targetDir, "testfile")
+
+	for i, expected := range []bool{true, false} {
+		changed, err := download.DownloadIfChanged(srv.URL+"/testfile", dest, download.FileOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if changed != expected {
+			t.Fatalf("download %d: wrong changed, expected %t, actual %t", i, expected, changed)
+		}
+	}
+	if requests != 2 {
+		t.Fatalf("wrong number of requests, expected %d, actual %d", 2, requests)
+	}
+
+	lastModified = lastModified.Add(time.Hour)
+	changed, err := download.DownloadIfChanged(srv.URL+"/testfile", dest, download.FileOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed {
+		t.Fatal("expected changed after resource was modified")
+	}
+	fi, err := os.Stat(dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !fi.ModTime().Equal(lastModified) {
+		t.Fatalf("wrong modification time, expected %s, actual %s", lastModified, fi.ModTime())
+	}
+}
+
+func TestDownloadIfChangedETagFile(t *testing.T) {
+	etag := `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", etag)
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("12345\n"))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	dest := filepath.Join(targetDir, "testfile")
+
+	for i, tc := range []struct {
+		etag     string
+		options  download.FileOptions
+		expected bool
+	}{
+		{`"v1"`, download.FileOptions{}, true},
+		{`"v1"`, download.FileOptions{}, false},
+		{`"v1"`, download.FileOptions{DisableETagFile: true}, true},
+		{`"v2"`, download.FileOptions{}, true},
+		{`"v2"`, download.FileOptions{}, false},
+		{`"v2"`, download.FileOptions{ETagFile: filepath.Join(targetDir, "custom.etag")}, true},
+		{`"v2"`, download.FileOptions{ETagFile: filepath.Join(targetDir, "custom.etag")}, false},
+	} {
+		etag = tc.etag
+		changed, err := download.DownloadIfChanged(srv.URL+"/testfile", dest, tc.options)
+		if err != nil {
+			t.Fatalf("download %d: unexpected error: %v", i, err)
+		}
+		if changed != tc.expected {
+			t.Fatalf("download %d: wrong changed, expected %t, actual %t", i, tc.expected, changed)
+		}
+	}
+
+	stored, err := ioutil.ReadFile(dest + ".etag")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.TrimSpace(string(stored)) != `"v2"` {
+		t.Fatalf("wrong stored ETag, expected %s, actual %s", `"v2"`, stored)
+	}
+}
+
+func TestDownloadIfChangedSkipped(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("12345\n"))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	dest := filepath.Join(targetDir, "testfile")
+
+	if _, err := download.DownloadIfChanged(srv.URL+"/testfile", dest, download.FileOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	changed, err := download.DownloadIfChanged(srv.URL+"/testfile", dest, download.FileOptions{IfExists: download.IfExistsSkip})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed {
+		t.Fatal("expected skipped download to be reported as unchanged")
+	}
+	stored, err := ioutil.ReadFile(dest + ".etag")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.TrimSpace(string(stored)) != `"v1"` {
+		t.Fatalf("wrong stored ETag, expected %s, actual %s", `"v1"`, stored)
+	}
+}
+
+func TestDownloadToFileKeepPartialOnError(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	checksumMismatch := func(err error) bool {
+		return strings.Contains(err.Error(), "checksum validation failed")
+	}
+	for _, tc := range []struct {
+		src      string
+		options  download.FileOptions
+		expected int
+	}{
+		{"/testfile", download.FileOptions{}, 0},
+		{"/testfile", download.FileOptions{KeepPartialOnError: true}, 1},
+		{"/testfile", download.FileOptions{KeepPartialOnError: true, CleanupDecider: checksumMismatch}, 1},
+		{"/missing", download.FileOptions{KeepPartialOnError: true, CleanupDecider: checksumMismatch}, 0},
+	} {
+		if err := os.RemoveAll(targetDir); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tc.options.Checksum = "0000000000000000000000000000000000000000000000000000000000000000"
+		err := download.ToFile(srv.URL+tc.src, filepath.Join(targetDir, "testfile"), tc.options)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		files, err := ioutil.ReadDir(targetDir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(files) != tc.expected {
+			t.Fatalf("%s %+v: wrong number of files kept, expected %d, actual %d", tc.src, tc.options, tc.expected, len(files))
+		}
+	}
+}
+
+type plainWriter struct{}
+
+func (plainWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func BenchmarkDownloadToWriterAllocs(b *testing.B) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	for _, bm := range []struct {
+		name       string
+		bufferSize int
+	}{
+		{"Default", 0},
+		{"BufferSize", 32 * 1024},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			options := download.Options{
+				Checksum:   "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+				BufferSize: bm.bufferSize,
+			}
+			for i := 0; i < b.N; i++ {
+				if err := download.ToWriter(srv.URL+"/testfile", plainWriter{}, options); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadToWriterSubresourceIntegrity(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	const (
+		sha384 = "sha384-Thy7AIrKpluniOPxUPeoaJyPyiiaV6Ze9lso8RumHlnD9N3wacqVIamsDgLq3k2u"
+		sha512 = "sha512-8twBGcnaxG9J07fQvh9hrfdhm3cP8Hb7EaL2H/P8umto0iRYjEmDZw2jGzO076vUSOOKL9pQhiLMM/+DBN30nA=="
+		wrong  = "sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	)
+	for _, tc := range []struct {
+		checksum string
+		ok       bool
+	}{
+		{sha384, true},
+		{sha512 + "?opt", true},
+		{wrong + " " + sha384, true},
+		{wrong, false},
+		{"sha384-!!!", false},
+	} {
+		result, err := download.ToWriterWithResult(srv.URL+"/testfile", ioutil.Discard, download.Options{Checksum: tc.checksum})
+		if !tc.ok {
+			if err == nil {
+				t.Fatalf("%s: expected error", tc.checksum)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.checksum, err)
+		}
+		if !result.ChecksumVerified {
+			t.Fatalf("%s: expected checksum to be verified", tc.checksum)
+		}
+	}
+}
+
+func TestDownloadToBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/chunked" {
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write([]byte("12345\n"))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path     string
+		maxBytes int64
+		ok       bool
+	}{
+		{"/testfile", 0, true},
+		{"/testfile", 6, true},
+		{"/testfile", 5, false},
+		{"/chunked", 6, true},
+		{"/chunked", 5, false},
+	} {
+		b, err := download.ToBytes(srv.URL+tc.path, download.Options{MaxBytes: tc.maxBytes})
+		if !tc.ok {
+			if !errors.Is(err, download.ErrTooLarge) {
+				t.Fatalf("%s %d: unexpected error, expected: '%v', actual: '%v'", tc.path, tc.maxBytes, download.ErrTooLarge, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s %d: unexpected error: %v", tc.path, tc.maxBytes, err)
+		}
+		if string(b) != "12345\n" {
+			t.Fatalf("%s %d: wrong downloaded data: %q", tc.path, tc.maxBytes, b)
+		}
+	}
+}
+
+func TestDownloadToBytesMinBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/empty" {
+			return
+		}
+		_, _ = w.Write([]byte("12345\n"))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path     string
+		minBytes int64
+		err      error
+	}{
+		{"/testfile", 0, nil},
+		{"/testfile", 6, nil},
+		{"/testfile", 7, download.ErrShortDownload},
+		{"/empty", 0, nil},
+		{"/empty", 1, download.ErrEmptyResponse},
+	} {
+		_, err := download.ToBytes(srv.URL+tc.path, download.Options{MinBytes: tc.minBytes})
+		if tc.err == nil && err != nil {
+			t.Fatalf("%s %d: unexpected error: %v", tc.path, tc.minBytes, err)
+		}
+		if tc.err != nil && !errors.Is(err, tc.err) {
+			t.Fatalf("%s %d: unexpected error, expected: '%v', actual: '%v'", tc.path, tc.minBytes, tc.err, err)
+		}
+	}
+}
+
+func TestDownloadToWriterAppendFrom(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/norange":
+			_, _ = w.Write([]byte("12345\n"))
+		case "/badrange":
+			w.Header().Set("Content-Range", "bytes 0-5/6")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte("12345\n"))
+		default:
+			http.ServeContent(w, req, "testfile", time.Time{}, strings.NewReader("12345\n"))
+		}
+	}))
+	defer srv.Close()
+
+	seed := sha256.New()
+	_, _ = seed.Write([]byte("123")) // #nosec
+
+	for _, tc := range []struct {
+		path     string
+		options  download.Options
+		expected string
+		ok       bool
+	}{
+		{"/testfile", download.Options{AppendFrom: 3}, "45\n", true},
+		{"/norange", download.Options{AppendFrom: 3}, "45\n", true},
+		{"/testfile", download.Options{AppendFrom: 6}, "", true},
+		{"/testfile", download.Options{
+			AppendFrom:   3,
+			Checksum:     "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+			ChecksumSeed: seed,
+		}, "45\n", true},
+		{"/testfile", download.Options{
+			AppendFrom: 3,
+			Checksum:   "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+		}, "", false},
+		{"/badrange", download.Options{AppendFrom: 3}, "", false},
+		{"/norange", download.Options{AppendFrom: 7}, "", false},
+	} {
+		var buf bytes.Buffer
+		err := download.ToWriter(srv.URL+tc.path, &buf, tc.options)
+		if !tc.ok {
+			if err == nil {
+				t.Fatalf("%s from %d: expected error", tc.path, tc.options.AppendFrom)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s from %d: unexpected error: %v", tc.path, tc.options.AppendFrom, err)
+		}
+		if buf.String() != tc.expected {
+			t.Fatalf("%s from %d: wrong downloaded data, expected %q, actual %q", tc.path, tc.options.AppendFrom, tc.expected, buf.String())
+		}
+	}
+}
+
+func TestDownloadToFileRetryReusesProgressBar(t *testing.T) {
+	i := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "6")
+		if i < 2 {
+			i++
+			_, _ = w.Write([]byte("123"))
+			return
+		}
+		_, _ = w.Write([]byte("12345\n"))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	var out bytes.Buffer
+	err := download.ToFile(srv.URL+"/testfile", filepath.Join(targetDir, "testfile"), download.FileOptions{
+		Options: download.Options{
+			ProgressBars: &download.ProgressBarOptions{Writer: &out},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bars := strings.Count(out.String(), "\n"); bars != 1 {
+		t.Fatalf("wrong number of progress bars, expected %d, actual %d: %q", 1, bars, out.String())
+	}
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestDownloadToWriterWeakHashWarning(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	var logger recordingLogger
+	options := download.Options{
+		Checksum:               srv.URL + "/testfile.sha1",
+		ChecksumHash:           crypto.SHA1,
+		Logger:                 &logger,
+		DisableWeakHashWarning: true,
+	}
+	if err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(logger.messages) != 0 {
+		t.Fatalf("expected no warnings, actual %v", logger.messages)
+	}
+
+	options.DisableWeakHashWarning = false
+	for i := 0; i < 2; i++ {
+		if err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, options); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(logger.messages) != 1 || !strings.Contains(logger.messages[0], "SHA1") {
+		t.Fatalf("expected a single SHA1 warning, actual %v", logger.messages)
+	}
+}
+
+func TestDownloadOpenSeekable(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	rsc, err := download.OpenSeekable(srv.URL+"/testfile", download.Options{
+		Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = rsc.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadAll(rsc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(b) != "45\n" {
+		t.Fatalf("wrong data read, expected %q, actual %q", "45\n", b)
+	}
+	name := rsc.(interface{ Name() string }).Name()
+	if err = rsc.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("expected temp file %s to be removed", name)
+	}
+
+	_, err = download.OpenSeekable(srv.URL+"/testfile", download.Options{
+		Checksum: "0000000000000000000000000000000000000000000000000000000000000000",
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDownloadToWriterPrecheck(t *testing.T) {
+	var gets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/nohead" && req.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Method == http.MethodGet {
+			gets++
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if req.URL.Path == "/chunked" {
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write([]byte("12345\n"))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path     string
+		precheck download.Precheck
+		gets     int
+		err      error
+	}{
+		{"/testfile", download.Precheck{ContentTypes: []string{"text/plain"}, MaxBytes: 6}, 1, nil},
+		{"/testfile", download.Precheck{ContentTypes: []string{"text/*"}}, 1, nil},
+		{"/testfile", download.Precheck{ContentTypes: []string{"application/zip"}}, 0, download.ErrPrecheckFailed},
+		{"/testfile", download.Precheck{MaxBytes: 5}, 0, download.ErrPrecheckFailed},
+		{"/nohead", download.Precheck{ContentTypes: []string{"application/zip"}}, 1, download.ErrPrecheckFailed},
+		{"/chunked", download.Precheck{MaxBytes: 5}, 1, download.ErrTooLarge},
+	} {
+		gets = 0
+		err := download.ToWriter(srv.URL+tc.path, ioutil.Discard, download.Options{Precheck: &tc.precheck})
+		if tc.err == nil && err != nil {
+			t.Fatalf("%s %+v: unexpected error: %v", tc.path, tc.precheck, err)
+		}
+		if tc.err != nil && !errors.Is(err, tc.err) {
+			t.Fatalf("%s %+v: unexpected error, expected: '%v', actual: '%v'", tc.path, tc.precheck, tc.err, err)
+		}
+		if gets != tc.gets {
+			t.Fatalf("%s %+v: wrong number of GET requests, expected %d, actual %d", tc.path, tc.precheck, tc.gets, gets)
+		}
+	}
+}
+
+func TestDownloadToWriterStoreChecksumHeader(t *testing.T) {
+	hfs := http.FileServer(http.Dir("testdata"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Amz-Checksum-Sha256", "8zrjvJoizXVkmQp5R4mVRAmXcBOWb7Go9Dw1d2uDOpU=")
+		w.Header().Set("X-Amz-Meta-Sha256", "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95")
+		w.Header().Set("X-Bad-Sha256", "0000000000000000000000000000000000000000000000000000000000000000")
+		hfs.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		header string
+		ok     bool
+	}{
+		{"", true},
+		{"X-Amz-Meta-Sha256", true},
+		{"X-Bad-Sha256", false},
+		{"X-Missing", false},
+	} {
+		result, err := download.ToWriterWithResult(srv.URL+"/testfile", ioutil.Discard, download.Options{
+			VerifyStoreChecksumHeader: true,
+			StoreChecksumHeader:       tc.header,
+		})
+		if !tc.ok {
+			if err == nil {
+				t.Fatalf("%q: expected error", tc.header)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tc.header, err)
+		}
+		if !result.ChecksumVerified {
+			t.Fatalf("%q: expected checksum to be verified", tc.header)
+		}
+	}
+}
+
+func TestDownloadToWriterContentMD5(t *testing.T) {
+	hfs := http.FileServer(http.Dir("testdata"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Query().Get("md5") {
+		case "good":
+			w.Header().Set("Content-MD5", "1XcnP/iFw/hNrbhXi7QTmQ==")
+		case "bad":
+			w.Header().Set("Content-MD5", "AAAAAAAAAAAAAAAAAAAAAA==")
+		}
+		hfs.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		md5      string
+		optional bool
+		ok       bool
+		verified bool
+	}{
+		{"good", false, true, true},
+		{"bad", false, false, false},
+		{"", false, false, false},
+		{"", true, true, false},
+	} {
+		result, err := download.ToWriterWithResult(srv.URL+"/testfile?md5="+tc.md5, ioutil.Discard, download.Options{
+			VerifyContentMD5:   true,
+			ContentMD5Optional: tc.optional,
+		})
+		if !tc.ok {
+			if err == nil {
+				t.Fatalf("%q: expected error", tc.md5)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tc.md5, err)
+		}
+		if result.ChecksumVerified != tc.verified {
+			t.Fatalf("%q: wrong ChecksumVerified, expected %v, actual %v", tc.md5, tc.verified, result.ChecksumVerified)
+		}
+	}
+}
+
+func TestDownloadToWriterAllowedFinalHosts(t *testing.T) {
+	target := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer target.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, strings.Replace(target.URL, "127.0.0.1", "localhost", 1)+"/testfile", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		allowed []string
+		ok      bool
+	}{
+		{nil, true},
+		{[]string{"localhost"}, true},
+		{[]string{"*host"}, true},
+		{[]string{"127.0.0.1"}, false},
+		{[]string{"localhost:1"}, false},
+	} {
+		err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{AllowedFinalHosts: tc.allowed})
+		if tc.ok && err != nil {
+			t.Fatalf("%v: unexpected error: %v", tc.allowed, err)
+		}
+		if !tc.ok && !errors.Is(err, download.ErrDisallowedHost) {
+			t.Fatalf("%v: unexpected error, expected: '%v', actual: '%v'", tc.allowed, download.ErrDisallowedHost, err)
+		}
+	}
+}
+
+func TestDownloadToWriterChecksumMismatchDiagnostics(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		options  download.Options
+		contains []string
+	}{
+		{
+			download.Options{Checksum: "0000000000000000000000000000000000000000000000000000000000000000"},
+			[]string{"computed f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95", "read 6 bytes"},
+		},
+		{
+			download.Options{Checksum: "0000000000000000000000000000000000000000000000000000000000000000", DiagnosticHash: crypto.MD5},
+			[]string{"computed f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95", "read 6 bytes with MD5 d577273ff885c3f84dadb8578bb41399"},
+		},
+		{
+			download.Options{Checksum: "sha256-AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="},
+			[]string{"computed sha256-8zrjvJoizXVkmQp5R4mVRAmXcBOWb7Go9Dw1d2uDOpU=", "read 6 bytes"},
+		},
+	} {
+		err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, tc.options)
+		if !errors.Is(err, download.ErrChecksumMismatch) {
+			t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrChecksumMismatch, err)
+		}
+		for _, s := range tc.contains {
+			if !strings.Contains(err.Error(), s) {
+				t.Fatalf("unexpected error, expected to contain: '%s', actual: '%v'", s, err)
+			}
+		}
+	}
+}
+
+func TestDownloadToFileDestTemplate(t *testing.T) {
+	hf := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/latest" {
+			http.Redirect(w, req, "/v1.2.3/testfile", http.StatusFound)
+			return
+		}
+		if req.URL.Path == "/attachment" {
+			w.Header().Set("Content-Disposition", `attachment; filename="named.txt"`)
+		}
+		w.Header().Set("ETag", `"abc123"`)
+		http.ServeFile(w, req, filepath.Join("testdata", "testfile"))
+	})
+	srv := httptest.NewServer(hf)
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	for _, tc := range []struct {
+		src, template, expected string
+	}{
+		{"/latest", "{filename}", "testfile"},
+		{"/attachment", "{final-host}/{filename}", filepath.Join("127.0.0.1", "named.txt")},
+		{"/latest", "by-etag/{etag}", filepath.Join("by-etag", "abc123")},
+	} {
+		result, err := download.ToFileWithResult(srv.URL+tc.src, filepath.Join(targetDir, tc.template), download.FileOptions{DestTemplate: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := filepath.Join(targetDir, tc.expected)
+		if result.Path != expected {
+			t.Fatalf("unexpected path, expected: '%s', actual: '%s'", expected, result.Path)
+		}
+		if _, err = os.Stat(expected); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	err := download.ToFile(srv.URL+"/latest", filepath.Join(targetDir, "{filename}"), download.FileOptions{DestTemplate: true, DirectWrite: true})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPeek(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	noRanges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write(large)
+	}))
+	defer noRanges.Close()
+
+	for _, tc := range []struct {
+		src      string
+		n        int
+		expected string
+	}{
+		{srv.URL + "/testfile", 3, "123"},
+		{srv.URL + "/testfile", 100, "12345\n"},
+		{noRanges.URL, 4, "0123"},
+	} {
+		b, err := download.Peek(tc.src, tc.n, download.Options{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(b) != tc.expected {
+			t.Fatalf("unexpected bytes, expected: '%s', actual: '%s'", tc.expected, b)
+		}
+	}
+
+	if _, err := download.Peek(srv.URL+"/missing", 3, download.Options{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDownloadToWriterMutableSourceGuard(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		etag = `"v1"`
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("ETag", etag)
+		if req.Method == http.MethodHead {
+			if req.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+			}
+			return
+		}
+		_, _ = w.Write([]byte("12345\n"))
+		if req.URL.Path == "/changing" {
+			etag = `"v2"`
+		}
+	}))
+	defer srv.Close()
+
+	options := download.Options{MutableSourceGuard: true}
+	if err := download.ToWriter(srv.URL+"/stable", ioutil.Discard, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := download.ToWriter(srv.URL+"/changing", ioutil.Discard, options)
+	if !errors.Is(err, download.ErrResourceChanged) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrResourceChanged, err)
+	}
+}
+
+func TestDownloadToFileOnTempReady(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	var scanned string
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+		OnTempReady: func(path string) error {
+			scanned = path
+			if _, err := os.Stat(tmpFile); !os.IsNotExist(err) {
+				t.Fatalf("destination exists before OnTempReady returned: %v", err)
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if string(b) != "12345\n" {
+				t.Fatalf("unexpected temp file contents: '%s'", b)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = os.Stat(scanned); !os.IsNotExist(err) {
+		t.Fatalf("expected temp file to have been moved, got: %v", err)
+	}
+
+	rejected := errors.New("infected")
+	err = download.ToFile(srv.URL+"/testfile", filepath.Join(targetDir, "rejected"), download.FileOptions{
+		OnTempReady: func(path string) error {
+			scanned = path
+			return rejected
+		},
+	})
+	if !errors.Is(err, rejected) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", rejected, err)
+	}
+	for _, path := range []string{scanned, filepath.Join(targetDir, "rejected")} {
+		if _, err = os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to not exist, got: %v", path, err)
+		}
+	}
+}
+
+func TestDownloadToWriterMinThroughput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		_, _ = w.Write([]byte("1"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	err := download.ToWriter(srv.URL, ioutil.Discard, download.Options{
+		MinThroughput:    1024,
+		ThroughputWindow: 200 * time.Millisecond,
+	})
+	if !errors.Is(err, download.ErrTooSlow) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrTooSlow, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("took too long to abort slow download: %v", elapsed)
+	}
+
+	fast := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer fast.Close()
+	if err = download.ToWriter(fast.URL+"/testfile", ioutil.Discard, download.Options{MinThroughput: 1024}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDownloadURLVariants(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	u, err := url.Parse(srv.URL + "/testfile")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	options := download.Options{Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95"}
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	if err = download.ToFileURL(u, tmpFile, download.FileOptions{Options: options}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err = download.ToWriterURL(u, &buf, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "12345\n" {
+		t.Fatalf("unexpected contents: '%s'", buf.String())
+	}
+
+	if err = download.ToFileURL(nil, tmpFile, download.FileOptions{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDownloadToFileMkdirParentOnly(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	options := download.FileOptions{Mkdirs: download.MkdirParentOnly}
+	err = download.ToFile(srv.URL+"/testfile", filepath.Join(targetDir, "parent", "testfile"), options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deep := filepath.Join(targetDir, "typo", "deep", "testfile")
+	if err = download.ToFile(srv.URL+"/testfile", deep, options); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err = os.Stat(filepath.Join(targetDir, "typo")); !os.IsNotExist(err) {
+		t.Fatalf("expected directory to not have been created, got: %v", err)
+	}
+}
+
+func TestDownloadUnsupportedScheme(t *testing.T) {
+	for _, src := range []string{"ftp://example.com/file", "gopher://example.com/file", "example.com/file"} {
+		err := download.ToWriter(src, ioutil.Discard, download.Options{})
+		if !errors.Is(err, download.ErrUnsupportedScheme) {
+			t.Fatalf("%s: unexpected error, expected: '%v', actual: '%v'", src, download.ErrUnsupportedScheme, err)
+		}
+		err = download.ToFile(src, filepath.Join("testdata", "output", "file"), download.FileOptions{})
+		if !errors.Is(err, download.ErrUnsupportedScheme) {
+			t.Fatalf("%s: unexpected error, expected: '%v', actual: '%v'", src, download.ErrUnsupportedScheme, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("testdata", "output")); !os.IsNotExist(err) {
+		t.Fatalf("expected output directory to not have been created, got: %v", err)
+	}
+}
+
+type dirFetcher string
+
+func (d dirFetcher) Fetch(ctx context.Context, src *url.URL) (io.ReadCloser, int64, error) {
+	f, err := os.Open(filepath.Join(string(d), filepath.FromSlash(src.Path)))
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close() // #nosec
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+func TestDownloadRegisteredScheme(t *testing.T) {
+	download.RegisterScheme("testdata", dirFetcher("testdata"))
+
+	var buf bytes.Buffer
+	err := download.ToWriter("testdata://host/testfile", &buf, download.Options{
+		Checksum: "testdata://host/testfile.sha256",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "12345\n" {
+		t.Fatalf("unexpected contents: '%s'", buf.String())
+	}
+
+	err = download.ToWriter("testdata://host/missing", ioutil.Discard, download.Options{Retries: 1})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDownloadLocalFile(t *testing.T) {
+	src, err := filepath.Abs(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fileURL := &url.URL{Scheme: "file", Path: filepath.ToSlash(src)}
+
+	var (
+		buf      bytes.Buffer
+		progress bytes.Buffer
+	)
+	err = download.ToWriter(src, &buf, download.Options{
+		Checksum:     "file://" + filepath.ToSlash(src) + ".sha256",
+		ChecksumHash: crypto.SHA256,
+		ProgressBars: &download.ProgressBarOptions{Writer: &progress},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "12345\n" {
+		t.Fatalf("unexpected contents: '%s'", buf.String())
+	}
+	if !strings.Contains(progress.String(), "6 B / 6 B") {
+		t.Fatalf("unexpected progress output: '%s'", progress.String())
+	}
+
+	err = download.FromURL(fileURL, ioutil.Discard, download.Options{
+		Checksum:     "0000000000000000000000000000000000000000000000000000000000000000",
+		ChecksumHash: crypto.SHA256,
+	})
+	if !errors.Is(err, download.ErrChecksumMismatch) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrChecksumMismatch, err)
+	}
+
+	targetDir := filepath.Join("testdata", "output")
+	err = os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	dest := filepath.Join(targetDir, "testfile")
+	err = download.ToFileURL(&url.URL{Path: filepath.ToSlash(src)}, dest, download.FileOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	contents, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(contents) != "12345\n" {
+		t.Fatalf("unexpected contents: '%s'", contents)
+	}
+}
+
+func TestDownloadLocalFileMissing(t *testing.T) {
+	src, err := filepath.Abs(filepath.Join("testdata", "missing"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	start := time.Now()
+	err = download.ToWriter(src, ioutil.Discard, download.Options{RetryInterval: time.Second})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", os.ErrNotExist, err)
+	}
+	if !strings.Contains(err.Error(), src) {
+		t.Fatalf("expected error to name the missing file, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("expected missing file to not be retried, took %v", elapsed)
+	}
+
+	defer func() { _ = os.RemoveAll(filepath.Join("testdata", "output")) }() // #nosec
+	err = download.ToFile("file://"+filepath.ToSlash(src), filepath.Join("testdata", "output", "missing"), download.FileOptions{})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", os.ErrNotExist, err)
+	}
+}
+
+func TestDownloadToWriterExpectedSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Flushing before writing the body forces a chunked response without Content-Length.
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("12345\n"))
+	}))
+	defer srv.Close()
+
+	var progress bytes.Buffer
+	err := download.ToWriter(srv.URL, ioutil.Discard, download.Options{
+		ExpectedSize: 6,
+		ProgressBars: &download.ProgressBarOptions{Writer: &progress},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(progress.String(), "6 B / 6 B") {
+		t.Fatalf("unexpected progress output: '%s'", progress.String())
+	}
+
+	err = download.ToWriter(srv.URL, ioutil.Discard, download.Options{ExpectedSize: 6, MaxBytes: 5})
+	if !errors.Is(err, download.ErrTooLarge) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrTooLarge, err)
+	}
+}
+
+func TestDownloadToFileResume(t *testing.T) {
+	testData := []byte("12345\n")
+	var (
+		mu       sync.Mutex
+		ranges   []string
+		truncate bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, req.Header.Get("Range"))
+		cut := truncate
+		mu.Unlock()
+		if cut {
+			// Send half the file then drop the connection.
+			w.Header().Set("Content-Length", fmt.Sprint(len(testData)))
+			_, _ = w.Write(testData[:3])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+			return
+		}
+		http.ServeContent(w, req, "testfile", time.Time{}, bytes.NewReader(testData))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	partFile := download.PartFileName(tmpFile)
+	options := download.FileOptions{
+		Options: download.Options{
+			Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+			Retries:  1,
+		},
+		Resume: true,
+	}
+
+	// A failed download keeps the partial download and its resume state, without the
+	// credentials of the URL.
+	signedURL := strings.Replace(srv.URL, "http://", "http://user:secret@", 1) + "/testfile?signature=secret"
+	truncate = true
+	if err = download.ToFile(signedURL, tmpFile, options); err == nil {
+		t.Fatal("expected error")
+	}
+	if b, err := ioutil.ReadFile(partFile); err != nil || string(b) != "123" {
+		t.Fatalf("unexpected partial download: '%s' (%v)", b, err)
+	}
+	state, err := download.LoadResumeState(partFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state.URL != srv.URL+"/testfile" || state.Offset != 3 || state.HashType != crypto.SHA256 || len(state.HashState) == 0 {
+		t.Fatalf("unexpected resume state: %+v", state)
+	}
+	if b, err := ioutil.ReadFile(download.ResumeStateFileName(partFile)); err != nil || bytes.Contains(b, []byte("secret")) {
+		t.Fatalf("expected resume state without credentials, got: '%s' (%v)", b, err)
+	}
+
+	// Resuming, even with a refreshed signed URL, only requests the remaining bytes.
+	mu.Lock()
+	truncate, ranges = false, nil
+	mu.Unlock()
+	if err = download.ToFile(srv.URL+"/testfile?signature=refreshed", tmpFile, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, err := ioutil.ReadFile(tmpFile); err != nil || !bytes.Equal(b, testData) {
+		t.Fatalf("unexpected download: '%s' (%v)", b, err)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=3-" {
+		t.Fatalf("unexpected Range headers: %v", ranges)
+	}
+	for _, path := range []string{partFile, download.ResumeStateFileName(partFile)} {
+		if _, err = os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to have been removed, got: %v", path, err)
+		}
+	}
+}
+
+func TestDownloadToFileResumeState(t *testing.T) {
+	testData := []byte("12345\n")
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			mu.Lock()
+			ranges = append(ranges, req.Header.Get("Range"))
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, req, "testfile", time.Time{}, bytes.NewReader(testData))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	partFile := download.PartFileName(tmpFile)
+	src := srv.URL + "/testfile"
+
+	for _, tc := range []struct {
+		name    string
+		partial string
+		state   download.ResumeState
+		resumed bool
+	}{
+		{"unchanged", "123", download.ResumeState{URL: src, ETag: `"v1"`, Offset: 3}, true},
+		{"changed etag", "XYZ", download.ResumeState{URL: src, ETag: `"v0"`, Offset: 3}, false},
+		{"other url", "XYZ", download.ResumeState{URL: srv.URL + "/other", Offset: 3}, false},
+	} {
+		if err = ioutil.WriteFile(partFile, []byte(tc.partial), 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = download.SaveResumeState(partFile, tc.state); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mu.Lock()
+		ranges = nil
+		mu.Unlock()
+
+		err = download.ToFile(src, tmpFile, download.FileOptions{
+			Options: download.Options{
+				Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+			},
+			Resume: true,
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if b, err := ioutil.ReadFile(tmpFile); err != nil || !bytes.Equal(b, testData) {
+			t.Fatalf("%s: unexpected download: '%s' (%v)", tc.name, b, err)
+		}
+		expected := ""
+		if tc.resumed {
+			expected = "bytes=3-"
+		}
+		mu.Lock()
+		if len(ranges) != 1 || ranges[0] != expected {
+			t.Fatalf("%s: unexpected Range headers: %v", tc.name, ranges)
+		}
+		mu.Unlock()
+		if _, err = os.Stat(download.ResumeStateFileName(partFile)); !os.IsNotExist(err) {
+			t.Fatalf("%s: expected resume state to have been removed, got: %v", tc.name, err)
+		}
+	}
+}
+
+func TestDownloadToFileResumeRangeIgnored(t *testing.T) {
+	testData := []byte("12345\n")
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// Ignore any Range header.
+		_, _ = w.Write(testData) // #nosec
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	if err = ioutil.WriteFile(download.PartFileName(tmpFile), []byte("XYZ"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+		Options: download.Options{
+			Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+		},
+		Resume: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, err := ioutil.ReadFile(tmpFile); err != nil || !bytes.Equal(b, testData) {
+		t.Fatalf("unexpected download: '%s' (%v)", b, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestDownloadToFileResumeFrom(t *testing.T) {
+	testData := []byte("12345\n")
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, req.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, req, "testfile", time.Time{}, bytes.NewReader(testData))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	partFile := download.PartFileName(tmpFile)
+	if err = ioutil.WriteFile(partFile, []byte("12X"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	options := download.FileOptions{
+		Options: download.Options{
+			Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+		},
+		Resume:     true,
+		ResumeFrom: 4,
+	}
+	if err = download.ToFile(srv.URL+"/testfile", tmpFile, options); err == nil {
+		t.Fatal("expected error for ResumeFrom beyond the partial download")
+	}
+
+	options.ResumeFrom = 2
+	if err = download.ToFile(srv.URL+"/testfile", tmpFile, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, err := ioutil.ReadFile(tmpFile); err != nil || !bytes.Equal(b, testData) {
+		t.Fatalf("unexpected download: '%s' (%v)", b, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 1 || ranges[0] != "bytes=2-" {
+		t.Fatalf("unexpected Range headers: %v", ranges)
+	}
+}
+
+func TestDownloadToFileResumeTrustHashState(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "testfile")
+	partFile := download.PartFileName(tmpFile)
+
+	for _, trust := range []bool{true, false} {
+		// Save a hash state that doesn't match the partial download, so that trusting it
+		// fails validation and reading back the partial download passes.
+		if err = ioutil.WriteFile(partFile, []byte("123"), 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		h := sha256.New()
+		_, _ = h.Write([]byte("XYZ"))
+		if err = download.SaveHashState(partFile, h); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = download.ToFile(srv.URL+"/testfile", tmpFile, download.FileOptions{
+			Options: download.Options{
+				Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+				Retries:  1,
+			},
+			Resume:         true,
+			TrustHashState: trust,
+		})
+		if trust && !errors.Is(err, download.ErrChecksumMismatch) {
+			t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrChecksumMismatch, err)
+		}
+		if !trust && err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_ = os.Remove(partFile) // #nosec
+	}
+}
+
+func TestDownloadToWriterAcceptableChecksums(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	const (
+		old     = "0000000000000000000000000000000000000000000000000000000000000000"
+		current = "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95"
+	)
+	for _, tc := range []struct {
+		acceptable []string
+		ok         bool
+	}{
+		{[]string{old, current}, true},
+		{[]string{strings.ToUpper(current)}, true},
+		{[]string{old}, false},
+	} {
+		result, err := download.ToWriterWithResult(srv.URL+"/testfile", ioutil.Discard, download.Options{AcceptableChecksums: tc.acceptable})
+		if tc.ok {
+			if err != nil {
+				t.Fatalf("%v: unexpected error: %v", tc.acceptable, err)
+			}
+			if !result.ChecksumVerified {
+				t.Fatalf("%v: expected checksum to be verified", tc.acceptable)
+			}
+			continue
+		}
+		if !errors.Is(err, download.ErrChecksumMismatch) {
+			t.Fatalf("%v: unexpected error, expected: '%v', actual: '%v'", tc.acceptable, download.ErrChecksumMismatch, err)
+		}
+	}
+
+	err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{Checksum: old, AcceptableChecksums: []string{current}})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// slowReader delays each read, so that downloads take long enough to be interrupted.
+type slowReader struct {
+	*bytes.Reader
+}
+
+func (r slowReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	if len(p) > 1024 {
+		p = p[:1024]
+	}
+	return r.Reader.Read(p)
+}
+
+func TestStartDownloadPauseResume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, req.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, req, "data", time.Time{}, slowReader{bytes.NewReader(data)})
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "data")
+	transfer := download.StartDownload(srv.URL+"/data", tmpFile, download.FileOptions{
+		Options: download.Options{Checksum: fmt.Sprintf("%x", sha256.Sum256(data))},
+	})
+	for transfer.Progress().BytesComplete == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	transfer.Pause()
+	time.Sleep(50 * time.Millisecond)
+	paused := transfer.Progress()
+	if !paused.Paused || paused.Done {
+		t.Fatalf("unexpected progress while paused: %+v", paused)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if p := transfer.Progress(); p.BytesComplete != paused.BytesComplete {
+		t.Fatalf("download progressed while paused: %d -> %d", paused.BytesComplete, p.BytesComplete)
+	}
+
+	transfer.Resume()
+	if err = transfer.Wait(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p := transfer.Progress(); !p.Done || p.BytesComplete != int64(len(data)) || p.BytesTotal != int64(len(data)) {
+		t.Fatalf("unexpected progress when done: %+v", p)
+	}
+	downloaded, err := ioutil.ReadFile(tmpFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Fatal("wrong downloaded data")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != fmt.Sprintf("bytes=%d-", paused.BytesComplete) {
+		t.Fatalf("unexpected Range headers: %v", ranges)
+	}
+}
+
+func TestStartDownloadPauseImmediateResume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.ServeContent(w, req, "data", time.Time{}, slowReader{bytes.NewReader(data)})
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "data")
+	transfer := download.StartDownload(srv.URL+"/data", tmpFile, download.FileOptions{
+		Options: download.Options{Checksum: fmt.Sprintf("%x", sha256.Sum256(data))},
+	})
+	for transfer.Progress().BytesComplete == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// Resuming before the interrupted attempt has returned must still continue the transfer.
+	transfer.Pause()
+	transfer.Resume()
+	if err = transfer.Wait(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	downloaded, err := ioutil.ReadFile(tmpFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Fatal("wrong downloaded data")
+	}
+}
+
+func TestStartDownloadCancel(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.ServeContent(w, req, "data", time.Time{}, slowReader{bytes.NewReader(data)})
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tmpFile := filepath.Join(targetDir, "data")
+	transfer := download.StartDownload(srv.URL+"/data", tmpFile, download.FileOptions{})
+	for transfer.Progress().BytesComplete == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	transfer.Cancel()
+	if err = transfer.Wait(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", context.Canceled, err)
+	}
+	for _, path := range []string{tmpFile, download.PartFileName(tmpFile)} {
+		if _, err = os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to not exist, got: %v", path, err)
+		}
+	}
+}
+
+func TestDownloadToVersionedFile(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	current := filepath.Join(targetDir, "current")
+	for _, version := range []string{"app-v1", "app-v2"} {
+		err = download.ToVersionedFile(srv.URL+"/testfile", filepath.Join(targetDir, version), current, download.FileOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		target, err := os.Readlink(current)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if target != version {
+			t.Fatalf("wrong symlink target, expected %s, actual %s", version, target)
+		}
+		downloadedData, err := ioutil.ReadFile(current)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(testData, downloadedData) {
+			t.Fatal("wrong downloaded data")
+		}
+	}
+
+	err = download.ToVersionedFile(srv.URL+"/missing", filepath.Join(targetDir, "app-v3"), current, download.FileOptions{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if target, err := os.Readlink(current); err != nil || target != "app-v2" {
+		t.Fatalf("expected symlink to be unchanged, got %s: %v", target, err)
+	}
+
+	err = download.ToVersionedFile(srv.URL+"/testfile", filepath.Join(targetDir, "app-v4"), current, download.FileOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if target, err := os.Readlink(current); err != nil || target != "app-v2" {
+		t.Fatalf("expected symlink to be unchanged by dry run, got %s: %v", target, err)
+	}
+	if _, err = os.Stat(filepath.Join(targetDir, "app-v4")); !os.IsNotExist(err) {
+		t.Fatalf("expected dry run to not write the file, got: %v", err)
+	}
+}
+
+func TestDownloadToFileIfExists(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeFile(w, req, filepath.Join("testdata", "testfile"))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	testData, err := ioutil.ReadFile(filepath.Join("testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tmpFile := filepath.Join(targetDir, "testfile")
+	tests := []struct {
+		name     string
+		ifExists download.IfExists
+		existing string
+		skipped  bool
+	}{
+		{"overwrite", download.IfExistsOverwrite, string(testData), false},
+		{"skip", download.IfExistsSkip, "other", true},
+		{"skip if checksum matches", download.IfExistsSkipIfChecksumMatches, string(testData), true},
+		{"checksum mismatch", download.IfExistsSkipIfChecksumMatches, "other", false},
+	}
+	for _, tt := range tests {
+		if err = ioutil.WriteFile(tmpFile, []byte(tt.existing), 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		atomic.StoreInt32(&requests, 0)
+
+		result, err := download.ToFileWithResult(srv.URL+"/testfile", tmpFile, download.FileOptions{
+			Options: download.Options{
+				Checksum: "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+			},
+			IfExists: tt.ifExists,
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if result.Skipped != tt.skipped {
+			t.Fatalf("%s: expected skipped to be %t", tt.name, tt.skipped)
+		}
+		expectedRequests, expected := int32(1), string(testData)
+		if tt.skipped {
+			expectedRequests, expected = 0, tt.existing
+		}
+		if n := atomic.LoadInt32(&requests); n != expectedRequests {
+			t.Fatalf("%s: expected %d requests, got %d", tt.name, expectedRequests, n)
+		}
+		if b, err := ioutil.ReadFile(tmpFile); err != nil || string(b) != expected {
+			t.Fatalf("%s: unexpected file contents: '%s' (%v)", tt.name, b, err)
+		}
+	}
+}
+
+func TestDownloadToFileConcurrency(t *testing.T) {
+	testData := bytes.Repeat([]byte("0123456789"), 100)
+	sum := sha256.Sum256(testData)
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			mu.Lock()
+			ranges = append(ranges, req.Header.Get("Range"))
+			mu.Unlock()
+		}
+		if req.URL.Path == "/noranges" {
+			_, _ = w.Write(testData) // #nosec
+			return
+		}
+		http.ServeContent(w, req, "data", time.Time{}, bytes.NewReader(testData))
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	tests := []struct {
+		path   string
+		ranges []string
+	}{
+		{"/data", []string{"bytes=0-249", "bytes=250-499", "bytes=500-749", "bytes=750-999"}},
+		{"/noranges", []string{""}},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		ranges = nil
+		mu.Unlock()
+
+		tmpFile := filepath.Join(targetDir, "data")
+		result, err := download.ToFileWithResult(srv.URL+tt.path, tmpFile, download.FileOptions{
+			Options: download.Options{
+				Checksum:    fmt.Sprintf("%x", sum),
+				Concurrency: 4,
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.path, err)
+		}
+		if !result.ChecksumVerified {
+			t.Fatalf("%s: expected checksum to be verified", tt.path)
+		}
+		if b, err := ioutil.ReadFile(tmpFile); err != nil || !bytes.Equal(b, testData) {
+			t.Fatalf("%s: unexpected download of %d bytes (%v)", tt.path, len(b), err)
+		}
+		mu.Lock()
+		sort.Strings(ranges)
+		if strings.Join(ranges, ",") != strings.Join(tt.ranges, ",") {
+			t.Fatalf("%s: unexpected Range headers: %v", tt.path, ranges)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestDownloadToFileDryRun(t *testing.T) {
+	var gets int
+	hfs := http.FileServer(http.Dir("testdata"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			gets++
+		}
+		if req.URL.Path == "/redirect" {
+			http.Redirect(w, req, "/testfile", http.StatusFound)
+			return
+		}
+		hfs.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	dest := filepath.Join(targetDir, "testfile")
+	result, err := download.ToFileWithResult(srv.URL+"/redirect", dest, download.FileOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	report := result.DryRun
+	if report == nil {
+		t.Fatal("expected dry run report")
+	}
+	if report.URL.String() != srv.URL+"/testfile" || report.Size != 6 || !strings.HasPrefix(report.ContentType, "text/plain") || !report.WouldDownload {
+		t.Fatalf("unexpected dry run report: %+v", report)
+	}
+	if result.Path != dest {
+		t.Fatalf("wrong path, expected %s, actual %s", dest, result.Path)
+	}
+	if gets != 0 {
+		t.Fatalf("expected no GET requests, got %d", gets)
+	}
+	if _, err = os.Stat(targetDir); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be written, got: %v", err)
+	}
+
+	if _, err = download.DownloadIfChanged(srv.URL+"/testfile", dest, download.FileOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gets = 0
+	changed, err := download.DownloadIfChanged(srv.URL+"/testfile", dest, download.FileOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed || gets != 0 {
+		t.Fatalf("expected unchanged dry run without GET requests, got changed %v, %d GET requests", changed, gets)
+	}
+}
+
+type taggedError struct {
+	op  string
+	err error
+}
+
+func (e *taggedError) Error() string { return e.op + ": " + e.err.Error() }
+func (e *taggedError) Unwrap() error { return e.err }
+
+func TestDownloadWrapError(t *testing.T) {
+	var resets int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/reset" {
+			atomic.AddInt32(&resets, 1)
+			// Hijack and close to cause a retriable error.
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+			return
+		}
+		_, _ = w.Write([]byte("12345\n"))
+	}))
+	defer srv.Close()
+
+	var ops []string
+	wrap := func(op string, err error) error {
+		ops = append(ops, op)
+		return &taggedError{op, err}
+	}
+
+	_, err := download.ToBytes(srv.URL+"/testfile", download.Options{MaxBytes: 5, WrapError: wrap})
+	var tagged *taggedError
+	if !errors.As(err, &tagged) || tagged.op != "ToBytes" || !errors.Is(err, download.ErrTooLarge) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	err = download.ToFile(srv.URL+"/reset", filepath.Join(targetDir, "testfile"), download.FileOptions{
+		Options: download.Options{Retries: 3, WrapError: wrap},
+	})
+	if !errors.As(err, &tagged) || tagged.op != "ToFile" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&resets); n < 3 {
+		t.Fatalf("expected the download to be retried, got %d requests", n)
+	}
+	if len(ops) != 2 {
+		t.Fatalf("expected WrapError to be called once per operation, got %v", ops)
+	}
+
+	if err = download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{WrapError: wrap}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDownloadToFileDirectory(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	for _, src := range []string{srv.URL, srv.URL + "/", srv.URL + "/writabledir"} {
+		dest := filepath.Join(targetDir, "index")
+		err := download.ToFile(src, dest, download.FileOptions{})
+		if !errors.Is(err, download.ErrIsDirectory) {
+			t.Fatalf("%s: unexpected error, expected: '%v', actual: '%v'", src, download.ErrIsDirectory, err)
+		}
+		if _, err = os.Stat(dest); !os.IsNotExist(err) {
+			t.Fatalf("%s: expected nothing to be written, got: %v", src, err)
+		}
+	}
+
+	if _, err := download.ToBytes(srv.URL+"/", download.Options{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// cancelWriter cancels a context once anything has been written to it.
+type cancelWriter struct {
+	cancel context.CancelFunc
+}
+
+func (w cancelWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return len(p), nil
+}
+
+func TestDownloadContextCancel(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "12")
+		_, _ = w.Write([]byte("123456"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-done:
+		case <-req.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := download.ToWriterContext(ctx, srv.URL+"/testfile", cancelWriter{cancel}, download.Options{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", context.Canceled, err)
+	}
+
+	u, _ := url.Parse(srv.URL + "/testfile")
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err = download.FromURLContext(ctx, u, ioutil.Discard, download.Options{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", context.Canceled, err)
+	}
+
+	targetDir := filepath.Join("testdata", "output")
+	err = os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = download.ToFileContext(ctx, srv.URL+"/testfile", filepath.Join(targetDir, "testfile"), download.FileOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", context.DeadlineExceeded, err)
+	}
+	if files, _ := ioutil.ReadDir(targetDir); len(files) != 0 {
+		t.Fatalf("expected temp file to be removed, found %d files", len(files))
+	}
+}
+
+func TestDownloadChecksumURLContextCancel(t *testing.T) {
+	started := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/testfile.sha256" {
+			http.ServeFile(w, req, filepath.Join("testdata", "testfile"))
+			return
+		}
+		if req.Header.Get("X-Signature") != "signed" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("f33ae3bc"))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	done := make(chan error)
+	go func() {
+		done <- download.ToWriterContext(ctx, srv.URL+"/testfile", ioutil.Discard, download.Options{
+			Checksum: srv.URL + "/testfile.sha256",
+			SignRequest: func(req *http.Request) error {
+				req.Header.Set("X-Signature", "signed")
+				return nil
+			},
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error, expected: '%v', actual: '%v'", context.Canceled, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for cancelled checksum file download to return")
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	done := make(chan struct{})
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Length", "12")
+		_, _ = w.Write([]byte("123456"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-done:
+		case <-req.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	start := time.Now()
+	err := download.ToWriter(srv.URL+"/testfile", ioutil.Discard, download.Options{Timeout: 100 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", context.DeadlineExceeded, err)
+	}
+
+	targetDir := filepath.Join("testdata", "output")
+	err = os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	atomic.StoreInt32(&requests, 0)
+	err = download.ToFile(srv.URL+"/testfile", filepath.Join(targetDir, "testfile"), download.FileOptions{
+		Options: download.Options{
+			Timeout:       200 * time.Millisecond,
+			RetryInterval: time.Millisecond,
+			// Cut each attempt short so that the download is retried until it times out.
+			MinThroughput:    1000,
+			ThroughputWindow: 50 * time.Millisecond,
+			Retries:          -1,
+		},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", context.DeadlineExceeded, err)
+	}
+	if n := atomic.LoadInt32(&requests); n < 2 {
+		t.Fatalf("expected the download to be retried until it timed out, got %d requests", n)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected downloads to time out, took %v", elapsed)
+	}
+}
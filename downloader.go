@@ -0,0 +1,71 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"io"
+	"net/url"
+)
+
+// Downloader makes downloads with the same `Options`, e.g. the same HTTP client, headers and
+// progress bars, without passing them to each download. Its downloads share a connection pool
+// and, if `Options.MaxBytesPerSecond` is set, a rate limit, so that it limits their combined
+// rate rather than the rate of each of them. A Downloader is safe for concurrent use.
+type Downloader struct {
+	options Options
+}
+
+// New returns a Downloader making downloads with the specified `Options`.
+func New(options Options) *Downloader {
+	if options.MaxBytesPerSecond > 0 {
+		options.rateLimiter = newRateLimiter(options.MaxBytesPerSecond, getClock(options))
+	}
+	return &Downloader{options: options}
+}
+
+// ToFile downloads the specified `src` URL to `dest` file, as for the package level ToFile.
+func (d *Downloader) ToFile(src, dest string) error {
+	return d.ToFileContext(context.Background(), src, dest)
+}
+
+// ToFileContext is the same as ToFile but aborts the download as soon as `ctx` is done.
+func (d *Downloader) ToFileContext(ctx context.Context, src, dest string) error {
+	_, err := toFile(ctx, src, dest, FileOptions{Options: d.options})
+	return err
+}
+
+// ToWriter downloads the specified `src` URL to `w` writer, as for the package level
+// ToWriter.
+func (d *Downloader) ToWriter(src string, w io.Writer) error {
+	return d.ToWriterContext(context.Background(), src, w)
+}
+
+// ToWriterContext is the same as ToWriter but aborts the download as soon as `ctx` is done.
+func (d *Downloader) ToWriterContext(ctx context.Context, src string, w io.Writer) error {
+	_, err := toWriter(ctx, src, w, d.options)
+	return err
+}
+
+// FromURL downloads the specified `src` URL to `w` writer, as for the package level FromURL.
+func (d *Downloader) FromURL(src *url.URL, w io.Writer) error {
+	return d.FromURLContext(context.Background(), src, w)
+}
+
+// FromURLContext is the same as FromURL but aborts the download as soon as `ctx` is done.
+func (d *Downloader) FromURLContext(ctx context.Context, src *url.URL, w io.Writer) error {
+	_, err := fromURL(ctx, src, w, d.options)
+	return err
+}
@@ -0,0 +1,125 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	download "github.com/jimmidyson/go-download"
+)
+
+func TestDownloader(t *testing.T) {
+	hfs := http.FileServer(http.Dir("testdata"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		hfs.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	d := download.New(download.Options{
+		Headers:  http.Header{"Authorization": []string{"Bearer token"}},
+		Checksum: srv.URL + "/testfile.sha256",
+	})
+
+	var buf bytes.Buffer
+	err := d.ToWriter(srv.URL+"/testfile", &buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "12345\n" {
+		t.Fatalf("unexpected contents: '%s'", buf.String())
+	}
+
+	u, err := url.Parse(srv.URL + "/testfile")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf.Reset()
+	err = d.FromURL(u, &buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "12345\n" {
+		t.Fatalf("unexpected contents: '%s'", buf.String())
+	}
+
+	targetDir := filepath.Join("testdata", "output")
+	err = os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+	dest := filepath.Join(targetDir, "testfile")
+	err = d.ToFile(srv.URL+"/testfile", dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	contents, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(contents) != "12345\n" {
+		t.Fatalf("unexpected contents: '%s'", contents)
+	}
+
+	err = d.ToWriter(srv.URL+"/testfile.gz", ioutil.Discard)
+	if err == nil {
+		t.Fatal("expected checksum mismatch")
+	}
+}
+
+func TestDownloaderSharedRateLimit(t *testing.T) {
+	testData := bytes.Repeat([]byte("x"), 500)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write(testData) // #nosec
+	}))
+	defer srv.Close()
+
+	d := download.New(download.Options{MaxBytesPerSecond: 1000})
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, 2)
+	)
+	start := time.Now()
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = d.ToWriter(srv.URL, ioutil.Discard)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// Each download alone would take 0.5s.
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("expected downloads to share the rate limit and take at least 1s, took %v", elapsed)
+	}
+}
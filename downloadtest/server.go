@@ -0,0 +1,285 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package downloadtest provides utilities for testing code that uses go-download.
+package downloadtest
+
+import (
+	"bytes"
+	"crypto/md5" // #nosec
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// ChecksumsFile is the base name of the generated checksum files listing every file in the
+// served directory, e.g. `CHECKSUMS.sha256`.
+const ChecksumsFile = "CHECKSUMS"
+
+// checksumExtensions matches the checksum file names written by go-download, see
+// `download.ChecksumFileName`.
+var checksumExtensions = map[string]func() hash.Hash{
+	"md5":      md5.New, // #nosec
+	"sha1":     sha1.New,
+	"sha224":   sha256.New224,
+	"sha256":   sha256.New,
+	"sha384":   sha512.New384,
+	"sha512":   sha512.New,
+	"sha3-256": sha3.New256,
+	"sha3-512": sha3.New512,
+	"blake2b-512": func() hash.Hash {
+		h, _ := blake2b.New512(nil) // #nosec
+		return h
+	},
+}
+
+// Fault describes a failure to inject when serving a file.
+type Fault struct {
+	// StatusCode, if non-zero, is returned with an empty body instead of serving the file.
+	StatusCode int
+	// TruncateAfter, if positive, aborts the connection after this many bytes of the body
+	// have been written.
+	TruncateAfter int64
+	// Delay, if positive, is slept before writing each chunk of the body.
+	Delay time.Duration
+	// ChunkSize is the size of the chunks the body is written in when Delay is set. Defaults
+	// to 1024 if unset.
+	ChunkSize int
+	// Times is the number of requests the fault applies to before the file is served normally
+	// again. Set to 0 (default) to apply the fault to all requests.
+	Times int
+}
+
+// Server is an HTTP server serving the files in a directory. In addition to the files
+// themselves, a checksum file is generated on request for every supported algorithm (md5,
+// sha1, sha224, sha256, sha384, sha512, sha3-256, sha3-512 and blake2b-512):
+// `/<file>.<algorithm>` contains the checksum of `<file>`
+// and `/CHECKSUMS.<algorithm>` lists the checksums of every file in the directory, unless a
+// file with that name exists in the directory. Requests are counted and can have faults
+// injected per path.
+type Server struct {
+	*httptest.Server
+
+	dir string
+
+	mu       sync.Mutex
+	faults   map[string]*Fault
+	requests map[string]int
+}
+
+// NewServer starts and returns a new Server serving the files in dir. The caller should
+// call Close when finished, to shut it down.
+func NewServer(dir string) *Server {
+	s := &Server{
+		dir:      dir,
+		faults:   map[string]*Fault{},
+		requests: map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// URL returns the URL of the file with the given name.
+func (s *Server) URL(name string) string {
+	return s.Server.URL + "/" + strings.TrimPrefix(name, "/")
+}
+
+// SetFault injects fault into requests for the file with the given name. Pass `nil` to
+// remove any fault.
+func (s *Server) SetFault(name string, fault *Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = "/" + strings.TrimPrefix(name, "/")
+	if fault == nil {
+		delete(s.faults, name)
+		return
+	}
+	f := *fault
+	s.faults[name] = &f
+}
+
+// Requests returns the number of requests received for the file with the given name.
+func (s *Server) Requests(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests["/"+strings.TrimPrefix(name, "/")]
+}
+
+func (s *Server) fault(name string) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[name]++
+	f, ok := s.faults[name]
+	if !ok {
+		return nil
+	}
+	if f.Times > 0 {
+		f.Times--
+		if f.Times == 0 {
+			delete(s.faults, name)
+		}
+	}
+	fault := *f
+	return &fault
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	name := path.Clean("/" + req.URL.Path)
+	fault := s.fault(name)
+	if fault != nil && fault.StatusCode != 0 {
+		w.WriteHeader(fault.StatusCode)
+		return
+	}
+
+	content, modTime, err := s.content(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, req)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if fault != nil {
+		w = &faultWriter{ResponseWriter: w, fault: fault}
+	}
+	http.ServeContent(w, req, name, modTime, content)
+}
+
+// content returns the content to serve for name, generating checksum files if required.
+func (s *Server) content(name string) (io.ReadSeeker, time.Time, error) {
+	filename := filepath.Join(s.dir, filepath.FromSlash(name))
+	if f, err := os.Open(filename); err == nil {
+		defer func() { _ = f.Close() }() // #nosec
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if fi.IsDir() {
+			return nil, time.Time{}, os.ErrNotExist
+		}
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return bytes.NewReader(b), fi.ModTime(), nil
+	}
+
+	ext := path.Ext(name)
+	newHash, ok := checksumExtensions[strings.TrimPrefix(ext, ".")]
+	if !ok {
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	base := strings.TrimSuffix(path.Base(name), ext)
+
+	var files []string
+	if base == ChecksumsFile {
+		fis, err := ioutil.ReadDir(filepath.Join(s.dir, filepath.FromSlash(path.Dir(name))))
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		for _, fi := range fis {
+			if !fi.IsDir() {
+				files = append(files, fi.Name())
+			}
+		}
+		sort.Strings(files)
+	} else {
+		files = []string{base}
+	}
+
+	var buf bytes.Buffer
+	for _, file := range files {
+		digest, err := fileDigest(filepath.Join(s.dir, filepath.FromSlash(path.Dir(name)), file), newHash())
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		_, _ = fmt.Fprintf(&buf, "%s  %s\n", digest, file)
+	}
+	return bytes.NewReader(buf.Bytes()), time.Time{}, nil
+}
+
+func fileDigest(filename string, h hash.Hash) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }() // #nosec
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// faultWriter injects slow streaming and truncation into a response body.
+type faultWriter struct {
+	http.ResponseWriter
+	fault   *Fault
+	written int64
+}
+
+func (w *faultWriter) Write(p []byte) (int, error) {
+	chunkSize := len(p)
+	if w.fault.Delay > 0 {
+		chunkSize = w.fault.ChunkSize
+		if chunkSize <= 0 {
+			chunkSize = 1024
+		}
+	}
+
+	var n int
+	for n < len(p) {
+		chunk := p[n:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		if w.fault.TruncateAfter > 0 && w.written+int64(len(chunk)) > w.fault.TruncateAfter {
+			chunk = chunk[:w.fault.TruncateAfter-w.written]
+		}
+		if w.fault.Delay > 0 {
+			time.Sleep(w.fault.Delay)
+		}
+		m, err := w.ResponseWriter.Write(chunk)
+		n += m
+		w.written += int64(m)
+		if err != nil {
+			return n, err
+		}
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		if w.fault.TruncateAfter > 0 && w.written >= w.fault.TruncateAfter {
+			panic(http.ErrAbortHandler)
+		}
+	}
+	return n, nil
+}
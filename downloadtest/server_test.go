@@ -0,0 +1,156 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package downloadtest_test
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	download "github.com/jimmidyson/go-download"
+	"github.com/jimmidyson/go-download/downloadtest"
+)
+
+var checksumTests = []struct {
+	checksumFile string
+	hash         crypto.Hash
+}{
+	{"testfile.md5", crypto.MD5},
+	{"CHECKSUMS.md5", crypto.MD5},
+	{"testfile.sha1", crypto.SHA1},
+	{"CHECKSUMS.sha1", crypto.SHA1},
+	{"testfile.sha256", crypto.SHA256},
+	{"CHECKSUMS.sha256", crypto.SHA256},
+	{"testfile.sha512", crypto.SHA512},
+	{"CHECKSUMS.sha512", crypto.SHA512},
+	{"testfile.sha224", crypto.SHA224},
+	{"CHECKSUMS.sha224", crypto.SHA224},
+	{"testfile.sha384", crypto.SHA384},
+	{"CHECKSUMS.sha384", crypto.SHA384},
+	{"testfile.sha3-256", crypto.SHA3_256},
+	{"CHECKSUMS.sha3-256", crypto.SHA3_256},
+	{"testfile.sha3-512", crypto.SHA3_512},
+	{"CHECKSUMS.sha3-512", crypto.SHA3_512},
+	{"testfile.blake2b-512", crypto.BLAKE2b_512},
+	{"CHECKSUMS.blake2b-512", crypto.BLAKE2b_512},
+}
+
+func testData(t *testing.T) []byte {
+	b, err := ioutil.ReadFile(filepath.Join("..", "testdata", "testfile"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return b
+}
+
+func TestServerGeneratedChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "downloadtest")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }() // #nosec
+
+	err = ioutil.WriteFile(filepath.Join(dir, "testfile"), testData(t), 0644)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "otherfile"), []byte("other"), 0644)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	srv := downloadtest.NewServer(dir)
+	defer srv.Close()
+
+	for _, chk := range checksumTests {
+		var buf bytes.Buffer
+		err := download.ToWriter(srv.URL("testfile"), &buf, download.Options{
+			Checksum:     srv.URL(chk.checksumFile),
+			ChecksumHash: chk.hash,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error validating with %s: %v", chk.checksumFile, err)
+		}
+		if !bytes.Equal(testData(t), buf.Bytes()) {
+			t.Fatal("wrong downloaded data")
+		}
+	}
+}
+
+func TestServerFaultStatusCode(t *testing.T) {
+	srv := downloadtest.NewServer(filepath.Join("..", "testdata"))
+	defer srv.Close()
+
+	srv.SetFault("testfile", &downloadtest.Fault{StatusCode: http.StatusInternalServerError, Times: 1})
+
+	err := download.ToWriter(srv.URL("testfile"), ioutil.Discard, download.Options{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "received invalid status code: 500") {
+		t.Fatalf("unexpected error, expected to contain: '%s', actual: '%v'", "received invalid status code: 500", err)
+	}
+
+	var buf bytes.Buffer
+	err = download.ToWriter(srv.URL("testfile"), &buf, download.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(testData(t), buf.Bytes()) {
+		t.Fatal("wrong downloaded data")
+	}
+	if srv.Requests("testfile") != 2 {
+		t.Fatalf("wrong number of requests, expected %d, actual %d", 2, srv.Requests("testfile"))
+	}
+}
+
+func TestServerFaultTruncate(t *testing.T) {
+	srv := downloadtest.NewServer(filepath.Join("..", "testdata"))
+	defer srv.Close()
+
+	srv.SetFault("testfile", &downloadtest.Fault{TruncateAfter: 2})
+
+	err := download.ToWriter(srv.URL("testfile"), ioutil.Discard, download.Options{})
+	if !errors.Is(err, download.ErrShortDownload) {
+		t.Fatalf("unexpected error, expected: '%v', actual: '%v'", download.ErrShortDownload, err)
+	}
+}
+
+func TestServerFaultDelay(t *testing.T) {
+	srv := downloadtest.NewServer(filepath.Join("..", "testdata"))
+	defer srv.Close()
+
+	srv.SetFault("testfile", &downloadtest.Fault{Delay: 10 * time.Millisecond, ChunkSize: 1})
+
+	start := time.Now()
+	var buf bytes.Buffer
+	err := download.ToWriter(srv.URL("testfile"), &buf, download.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatalf("download too fast, expected at least %v, actual %v", 60*time.Millisecond, elapsed)
+	}
+	if !bytes.Equal(testData(t), buf.Bytes()) {
+		t.Fatal("wrong downloaded data")
+	}
+}
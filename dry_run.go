@@ -0,0 +1,109 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// DryRunReport describes what a download made with `FileOptions.DryRun` would have done.
+type DryRunReport struct {
+	// URL is the URL the download would be served from, after following redirects.
+	URL *url.URL
+	// Size is the size of the download reported by the server, or -1 if unknown.
+	Size int64
+	// ContentType is the Content-Type reported by the server.
+	ContentType string
+	// WouldDownload is false if the download would be skipped, e.g. because DownloadIfChanged
+	// found that the resource hasn't changed.
+	WouldDownload bool
+	// Reason explains why the download would be skipped.
+	Reason string
+}
+
+// dryRun makes a HEAD request for src, following redirects, and describes the download of
+// src to dest that would have been made. Nothing is written.
+func dryRun(ctx context.Context, src *url.URL, dest string, options FileOptions) (Result, error) {
+	httpClient := getHTTPClient(options.Options)
+	u, err := newSignedURL(src, options.Options).next()
+	if err != nil {
+		return Result{}, err
+	}
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "failed to create request")
+	}
+	setRequestHeaders(req, options.Options)
+	req = req.WithContext(ctx)
+	if options.Accept != "" {
+		req.Header.Set("Accept", options.Accept)
+	}
+	options.conditional.setHeaders(req)
+	if options.SignRequest != nil {
+		if err = options.SignRequest(req); err != nil {
+			return Result{}, errors.Wrap(err, "failed to sign request")
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "dry run failed")
+	}
+	_ = resp.Body.Close() // #nosec
+	if err = checkFinalHost(resp, options.AllowedFinalHosts); err != nil {
+		return Result{}, err
+	}
+	if err = checkNotDirectory(resp); err != nil {
+		return Result{}, err
+	}
+
+	report := &DryRunReport{
+		URL:           resp.Request.URL,
+		Size:          contentLength(resp, options.Options),
+		ContentType:   resp.Header.Get("Content-Type"),
+		WouldDownload: true,
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && options.conditional != nil:
+		report.WouldDownload = false
+		report.Reason = "not modified"
+	case resp.StatusCode != http.StatusOK:
+		return Result{}, errors.Wrap(newStatusError(resp, fmt.Sprintf("expected %d", http.StatusOK)), "dry run failed")
+	case options.Precheck != nil:
+		if err = options.Precheck.check(resp); err != nil {
+			return Result{}, err
+		}
+	}
+	if options.DestTemplate {
+		if dest, err = resolveDestTemplate(dest, resp); err != nil {
+			return Result{}, err
+		}
+	}
+
+	result := Result{
+		Attempts: 1,
+		ETag:     resp.Header.Get("ETag"),
+		Path:     dest,
+		DryRun:   report,
+	}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		result.LastModified = lastModified
+	}
+	return result, nil
+}
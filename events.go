@@ -0,0 +1,201 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType string
+
+const (
+	// EventStart is emitted when a download starts.
+	EventStart EventType = "start"
+	// EventProgress is emitted periodically while the response is read, and once it has been
+	// read in full.
+	EventProgress EventType = "progress"
+	// EventRetry is emitted before a failed request or download is retried.
+	EventRetry EventType = "retry"
+	// EventComplete is emitted when a download succeeds.
+	EventComplete EventType = "complete"
+	// EventError is emitted when a download fails.
+	EventError EventType = "error"
+)
+
+// progressEventInterval is the minimum interval between progress events.
+const progressEventInterval = 100 * time.Millisecond
+
+// Event is an event of a download, written to `Options.JSONEvents` as a line of JSON. Events
+// never include credentials: the user info, query and fragment of URLs are removed, including
+// from error messages.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// URL is the URL being downloaded.
+	URL string `json:"url"`
+	// Downloaded is the number of bytes read so far, for progress events.
+	Downloaded int64 `json:"downloaded,omitempty"`
+	// Total is the size of the download, or -1 if unknown, for progress events.
+	Total int64 `json:"total,omitempty"`
+	// Attempt is the number of the attempt about to be made, for retry events.
+	Attempt int `json:"attempt,omitempty"`
+	// ChecksumVerified is true if the download was validated against a checksum, for complete
+	// events.
+	ChecksumVerified bool `json:"checksumVerified,omitempty"`
+	// Error is the error that failed the download, for error events, or the attempt, for
+	// retry events.
+	Error string `json:"error,omitempty"`
+}
+
+// eventsMu serializes writes of events, so that the lines of concurrent downloads, e.g. of a
+// batch, sharing a writer aren't interleaved.
+var eventsMu sync.Mutex
+
+// eventEmitter writes the events of a download to `Options.JSONEvents`.
+type eventEmitter struct {
+	w     io.Writer
+	url   string
+	clock clock
+}
+
+// startEvents emits the start event of a download of src, returning the emitter to emit its
+// other events with. It returns nil if events are disabled or the download is part of an
+// outer download that has already started emitting them, e.g. the requests of a download to
+// a file.
+func startEvents(options *Options, src *url.URL) *eventEmitter {
+	if options.JSONEvents == nil || options.events != nil || src == nil {
+		return nil
+	}
+	e := &eventEmitter{w: options.JSONEvents, url: redactURL(src), clock: getClock(*options)}
+	options.events = e
+	e.emit(Event{Type: EventStart})
+	return e
+}
+
+// finish emits the complete or error event of a download.
+func (e *eventEmitter) finish(result Result, err error) {
+	if err != nil {
+		e.emit(Event{Type: EventError, Error: e.redact(err)})
+		return
+	}
+	e.emit(Event{Type: EventComplete, ChecksumVerified: result.ChecksumVerified})
+}
+
+// retrying wraps the download attempt fn to emit a retry event before each retry.
+func (e *eventEmitter) retrying(fn func() error) func() error {
+	if e == nil {
+		return fn
+	}
+	var (
+		attempt int
+		last    error
+	)
+	return func() error {
+		attempt++
+		if attempt > 1 {
+			e.emit(Event{Type: EventRetry, Attempt: attempt, Error: e.redact(last)})
+		}
+		last = fn()
+		return last
+	}
+}
+
+// progressReader returns r emitting progress events as it is read, or r itself if events are
+// disabled.
+func (e *eventEmitter) progressReader(r io.Reader, total int64) io.Reader {
+	if e == nil {
+		return r
+	}
+	if total < 0 {
+		total = -1
+	}
+	return &progressEventReader{r: r, e: e, total: total}
+}
+
+// progress emits a progress event.
+func (e *eventEmitter) progress(downloaded, total int64) {
+	e.emit(Event{Type: EventProgress, Downloaded: downloaded, Total: total})
+}
+
+func (e *eventEmitter) emit(event Event) {
+	if e == nil {
+		return
+	}
+	event.Time, event.URL = e.clock.Now().UTC(), e.url
+	b, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	_, _ = e.w.Write(append(b, '\n')) // #nosec
+}
+
+// redact returns the message of err with every URL in it redacted, as errors of the HTTP
+// client include the URLs requested, which may be refreshed or redirected URLs with
+// credentials of their own.
+func (e *eventEmitter) redact(err error) string {
+	if err == nil {
+		return ""
+	}
+	return messageURLPattern.ReplaceAllStringFunc(err.Error(), func(match string) string {
+		// Errors often follow a URL with a colon or close a parenthesis after it.
+		trimmed := strings.TrimRight(match, ":,;.)")
+		u, err := url.Parse(trimmed)
+		if err != nil {
+			// Unparseable, so drop everything but the scheme rather than risk leaking it.
+			return match[:strings.Index(match, "://")+3] + "<redacted>"
+		}
+		return redactURL(u) + match[len(trimmed):]
+	})
+}
+
+// messageURLPattern matches the URLs in error messages, which the HTTP client quotes.
+var messageURLPattern = regexp.MustCompile(`[A-Za-z][A-Za-z0-9+.-]*://[^\s"'<>]+`)
+
+// redactURL returns u without its user info, query and fragment, which may hold credentials,
+// e.g. the signature of a signed URL.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User, redacted.RawQuery, redacted.Fragment = nil, "", ""
+	return redacted.String()
+}
+
+// progressEventReader emits progress events as r is read.
+type progressEventReader struct {
+	r          io.Reader
+	e          *eventEmitter
+	total      int64
+	downloaded int64
+	last       time.Time
+}
+
+func (p *progressEventReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.downloaded += int64(n)
+	now := p.e.clock.Now()
+	if err == io.EOF || now.Sub(p.last) >= progressEventInterval {
+		p.last = now
+		p.e.progress(p.downloaded, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,188 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	download "github.com/jimmidyson/go-download"
+)
+
+func readEvents(t *testing.T, b []byte) []download.Event {
+	var events []download.Event
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		var event download.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestDownloadJSONEvents(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	hfs := http.FileServer(http.Dir("testdata"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		if first {
+			// Drop the connection to force a retry.
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close() // #nosec
+			return
+		}
+		hfs.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	base := strings.Replace(srv.URL, "http://", "http://user:secret@", 1)
+	src := base + "/testfile?token=secret"
+	var buf bytes.Buffer
+	err = download.ToFile(src, filepath.Join(targetDir, "testfile"), download.FileOptions{
+		Options: download.Options{
+			Checksum:      "f33ae3bc9a22cd7564990a794789954409977013966fb1a8f43c35776b833a95",
+			RetryInterval: time.Millisecond,
+			JSONEvents:    &buf,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Fatalf("events include credentials: %s", buf.String())
+	}
+	events := readEvents(t, buf.Bytes())
+	var types []string
+	for _, event := range events {
+		if event.URL != srv.URL+"/testfile" {
+			t.Fatalf("unexpected event URL: %s", event.URL)
+		}
+		if len(types) == 0 || types[len(types)-1] != string(event.Type) {
+			types = append(types, string(event.Type))
+		}
+	}
+	if strings.Join(types, ",") != "start,retry,progress,complete" {
+		t.Fatalf("unexpected events: %s", buf.String())
+	}
+	if progress := events[len(events)-2]; progress.Downloaded != 6 || progress.Total != 6 {
+		t.Fatalf("unexpected final progress: %+v", progress)
+	}
+	if !events[len(events)-1].ChecksumVerified {
+		t.Fatalf("expected checksum to be verified: %+v", events[len(events)-1])
+	}
+
+	buf.Reset()
+	err = download.ToWriter(base+"/missing?token=secret", ioutil.Discard, download.Options{JSONEvents: &buf})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Fatalf("events include credentials: %s", buf.String())
+	}
+	events = readEvents(t, buf.Bytes())
+	if len(events) != 2 || events[0].Type != download.EventStart || events[1].Type != download.EventError || !strings.Contains(events[1].Error, "404") {
+		t.Fatalf("unexpected events: %s", buf.String())
+	}
+}
+
+func TestDownloadJSONEventsConcurrency(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	targetDir := filepath.Join("testdata", "output")
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	var buf bytes.Buffer
+	err = download.ToFile(srv.URL+"/testfile", filepath.Join(targetDir, "testfile"), download.FileOptions{
+		Options: download.Options{Concurrency: 2, JSONEvents: &buf},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events := readEvents(t, buf.Bytes())
+	if len(events) < 3 || events[0].Type != download.EventStart || events[len(events)-1].Type != download.EventComplete {
+		t.Fatalf("unexpected events: %s", buf.String())
+	}
+	if progress := events[len(events)-2]; progress.Type != download.EventProgress || progress.Downloaded != 6 || progress.Total != 6 {
+		t.Fatalf("unexpected final progress: %+v", progress)
+	}
+}
+
+func TestDownloadJSONEventsRedactsRedirects(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/start" {
+			target := strings.Replace(srv.URL, "http://", "http://bob@", 1) + "/target?X-Amz-Signature=sekrit"
+			http.Redirect(w, req, target, http.StatusFound)
+			return
+		}
+		// Drop the connection so that the error names the redirect target.
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		_ = conn.Close() // #nosec
+	}))
+	defer srv.Close()
+
+	src := strings.Replace(srv.URL, "http://", "http://alice:pw@", 1) + "/start?token=tok"
+	var buf bytes.Buffer
+	err := download.ToWriter(src, ioutil.Discard, download.Options{
+		Retries:       2,
+		RetryInterval: time.Millisecond,
+		JSONEvents:    &buf,
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	events := readEvents(t, buf.Bytes())
+	if len(events) != 3 || events[1].Type != download.EventRetry || events[2].Type != download.EventError {
+		t.Fatalf("unexpected events: %s", buf.String())
+	}
+	if !strings.Contains(events[2].Error, srv.URL+"/target") {
+		t.Fatalf("expected error to name the redacted redirect target, got: %s", events[2].Error)
+	}
+	for _, secret := range []string{"alice", "pw", "bob", "sekrit", "tok"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Fatalf("events include credential %q: %s", secret, buf.String())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"crypto"
+	"net/url"
+	"path"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// defaultFilenameChecksumPattern matches a hex digest of a supported length delimited by
+// non-hex characters, e.g. the digest in `artifact-<sha256>.tar.gz`.
+var defaultFilenameChecksumPattern = regexp.MustCompile(`(?:^|[^0-9a-fA-F])([0-9a-fA-F]{128}|[0-9a-fA-F]{64}|[0-9a-fA-F]{40}|[0-9a-fA-F]{32})(?:[^0-9a-fA-F]|$)`)
+
+// hashTypesByDigestLength maps the length of a hex encoded digest to its hash function.
+var hashTypesByDigestLength = map[int]crypto.Hash{
+	32:  crypto.MD5,
+	40:  crypto.SHA1,
+	64:  crypto.SHA256,
+	128: crypto.SHA512,
+}
+
+// inferHashType returns the hash function of the hex encoded checksum from its length.
+func inferHashType(checksum string) (crypto.Hash, error) {
+	hashType, ok := hashTypesByDigestLength[len(checksum)]
+	if !ok {
+		return 0, checksumFormatError("unable to infer hash function of %d character checksum %s; specify ChecksumHash explicitly", len(checksum), checksum)
+	}
+	return hashType, nil
+}
+
+// resolveFilenameChecksum sets the checksum of options from the basename of src if
+// VerifyFromFilename is set.
+func resolveFilenameChecksum(src *url.URL, options *Options) error {
+	if !options.VerifyFromFilename {
+		return nil
+	}
+	if options.Checksum != "" {
+		return errors.New("Checksum and VerifyFromFilename are mutually exclusive")
+	}
+
+	pattern := options.FilenameChecksumPattern
+	if pattern == nil {
+		pattern = defaultFilenameChecksumPattern
+	}
+	base := path.Base(src.Path)
+	match := pattern.FindStringSubmatch(base)
+	if match == nil {
+		return errors.Errorf("no checksum found in filename %s", base)
+	}
+	digest := match[0]
+	if len(match) > 1 {
+		digest = match[1]
+	}
+	hashType, ok := hashTypesByDigestLength[len(digest)]
+	if !ok {
+		return errors.Errorf("unable to infer hash function of checksum %s in filename %s", digest, base)
+	}
+
+	options.Checksum = digest
+	options.ChecksumHash = hashType
+	options.VerifyFromFilename = false
+	return nil
+}
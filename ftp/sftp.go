@@ -0,0 +1,107 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ftp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	download "github.com/jimmidyson/go-download"
+)
+
+// SFTP fetches `sftp://` URLs.
+type SFTP struct {
+	// HostKeyCallback verifies the host key of the server. It is required.
+	HostKeyCallback ssh.HostKeyCallback
+	// Auth holds the methods to authenticate with. Defaults to the password in the URL.
+	Auth []ssh.AuthMethod
+	// Timeout is the timeout for connecting to the server. Defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+var _ download.Fetcher = &SFTP{}
+
+// Fetch implements `download.Fetcher`.
+func (s *SFTP) Fetch(ctx context.Context, src *url.URL) (io.ReadCloser, int64, error) {
+	if s.HostKeyCallback == nil {
+		return nil, 0, errors.New("SFTP HostKeyCallback is required")
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	config := &ssh.ClientConfig{
+		HostKeyCallback: s.HostKeyCallback,
+		Auth:            s.Auth,
+		Timeout:         timeout,
+	}
+	if src.User != nil {
+		config.User = src.User.Username()
+		if password, ok := src.User.Password(); ok && len(config.Auth) == 0 {
+			config.Auth = []ssh.AuthMethod{ssh.Password(password)}
+		}
+	}
+
+	addr := hostPort(src, "22")
+	dialer := net.Dialer{Timeout: timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to connect to SFTP server")
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	if err != nil {
+		_ = netConn.Close() // #nosec
+		return nil, 0, errors.Wrap(err, "failed to connect to SFTP server")
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close() // #nosec
+		return nil, 0, errors.Wrap(err, "failed to start SFTP session")
+	}
+
+	f, err := client.Open(src.Path)
+	if err != nil {
+		_ = client.Close()    // #nosec
+		_ = sshClient.Close() // #nosec
+		return nil, 0, errors.Wrapf(err, "failed to open %s", src.Path)
+	}
+	size := int64(-1)
+	if fi, err := f.Stat(); err == nil {
+		size = fi.Size()
+	}
+	return &sftpBody{File: f, client: client, sshClient: sshClient}, size, nil
+}
+
+// sftpBody closes the SFTP session and SSH connection once the file is closed.
+type sftpBody struct {
+	*sftp.File
+	client    *sftp.Client
+	sshClient *ssh.Client
+}
+
+func (b *sftpBody) Close() error {
+	err := b.File.Close()
+	_ = b.client.Close()    // #nosec
+	_ = b.sshClient.Close() // #nosec
+	return err
+}
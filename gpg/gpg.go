@@ -0,0 +1,80 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package gpg provides a `download.Verifier` checking detached GPG signatures of downloads:
+//
+//	keyRing, err := openpgp.ReadArmoredKeyRing(keys)
+//	...
+//	err = download.ToFile(src, dest, download.FileOptions{
+//		Verifier: &gpg.Verifier{KeyRing: keyRing, SignatureURL: src + ".asc"},
+//	})
+package gpg
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+
+	download "github.com/jimmidyson/go-download"
+)
+
+// maxSignatureSize is the default maximum size of a signature downloaded from SignatureURL.
+const maxSignatureSize = 1 << 20
+
+// Verifier verifies downloads against a detached GPG signature, armored or binary.
+type Verifier struct {
+	// KeyRing holds the public keys trusted to sign downloads.
+	KeyRing openpgp.KeyRing
+	// Signature is the detached signature of the download.
+	Signature []byte
+	// SignatureURL is the URL of the detached signature, e.g. the download URL with an `.asc`
+	// or `.sig` suffix. It is downloaded if Signature is empty.
+	SignatureURL string
+	// Options are the options to download SignatureURL with. MaxBytes defaults to 1MiB.
+	Options download.Options
+}
+
+var _ download.Verifier = &Verifier{}
+
+// Verify implements `download.Verifier`.
+func (v *Verifier) Verify(content io.Reader, _ download.Result) error {
+	if v.KeyRing == nil {
+		return errors.New("GPG KeyRing is required")
+	}
+	signature := v.Signature
+	if len(signature) == 0 {
+		if v.SignatureURL == "" {
+			return errors.New("GPG Signature or SignatureURL is required")
+		}
+		options := v.Options
+		if options.MaxBytes == 0 {
+			options.MaxBytes = maxSignatureSize
+		}
+		var err error
+		if signature, err = download.ToBytes(v.SignatureURL, options); err != nil {
+			return errors.Wrap(err, "failed to download signature")
+		}
+	}
+
+	check := openpgp.CheckDetachedSignature
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN PGP SIGNATURE-----")) {
+		check = openpgp.CheckArmoredDetachedSignature
+	}
+	if _, err := check(v.KeyRing, content, bytes.NewReader(signature)); err != nil {
+		return errors.Wrap(err, "invalid GPG signature")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package gpg_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+
+	download "github.com/jimmidyson/go-download"
+	"github.com/jimmidyson/go-download/gpg"
+)
+
+func TestVerifier(t *testing.T) {
+	signer, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content := []byte("12345\n")
+	var armored, binary bytes.Buffer
+	if err = openpgp.ArmoredDetachSign(&armored, signer, bytes.NewReader(content), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = openpgp.DetachSign(&binary, signer, bytes.NewReader(content), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/testfile":
+			_, _ = w.Write(content)
+		case "/testfile.asc":
+			_, _ = w.Write(armored.Bytes())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	targetDir, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(targetDir) }() // #nosec
+
+	for _, tc := range []struct {
+		name     string
+		verifier *gpg.Verifier
+		ok       bool
+	}{
+		{"armored", &gpg.Verifier{KeyRing: openpgp.EntityList{signer}, Signature: armored.Bytes()}, true},
+		{"binary", &gpg.Verifier{KeyRing: openpgp.EntityList{signer}, Signature: binary.Bytes()}, true},
+		{"url", &gpg.Verifier{KeyRing: openpgp.EntityList{signer}, SignatureURL: srv.URL + "/testfile.asc"}, true},
+		{"untrusted", &gpg.Verifier{KeyRing: openpgp.EntityList{other}, Signature: armored.Bytes()}, false},
+		{"missing", &gpg.Verifier{KeyRing: openpgp.EntityList{signer}, SignatureURL: srv.URL + "/testfile.sig"}, false},
+	} {
+		dest := filepath.Join(targetDir, tc.name)
+		err = download.ToFile(srv.URL+"/testfile", dest, download.FileOptions{Verifier: tc.verifier})
+		if !tc.ok {
+			if !errors.Is(err, download.ErrVerificationFailed) {
+				t.Fatalf("%s: unexpected error, expected: '%v', actual: '%v'", tc.name, download.ErrVerificationFailed, err)
+			}
+			if _, err = os.Stat(dest); !os.IsNotExist(err) {
+				t.Fatalf("%s: expected rejected download to be removed, got: %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+	}
+}
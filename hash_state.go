@@ -0,0 +1,87 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"crypto"
+	"encoding"
+	"hash"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// HashStateFileName returns the name of the file that SaveHashState persists the hash state
+// for the partial download `partPath` to.
+func HashStateFileName(partPath string) string {
+	return partPath + ".hashstate"
+}
+
+// SaveHashState persists the state of `h`, which must have consumed exactly the bytes of the
+// partial download `partPath`, alongside it so that hashing can later be resumed without
+// re-reading the partial download. `h` must implement encoding.BinaryMarshaler, as all of
+// the standard library hashes do.
+func SaveHashState(partPath string, h hash.Hash) error {
+	m, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return errors.New("hash does not support saving its state")
+	}
+	state, err := m.MarshalBinary()
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal hash state")
+	}
+	if err = ioutil.WriteFile(HashStateFileName(partPath), state, 0600); err != nil {
+		return errors.Wrap(err, "failed to write hash state")
+	}
+	return nil
+}
+
+// LoadHashState restores the state of `h` saved by SaveHashState for the partial download
+// `partPath`. `h` must be the same type of hash as the one saved.
+func LoadHashState(partPath string, h hash.Hash) error {
+	u, ok := h.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return errors.New("hash does not support restoring its state")
+	}
+	state, err := ioutil.ReadFile(HashStateFileName(partPath))
+	if err != nil {
+		return errors.Wrap(err, "failed to read hash state")
+	}
+	if err = u.UnmarshalBinary(state); err != nil {
+		return errors.Wrap(err, "failed to unmarshal hash state")
+	}
+	return nil
+}
+
+// cloneHasher returns a new hasher of hashType with the same state as h, so that h itself is
+// never modified and can be reused if the download is retried.
+func cloneHasher(h hash.Hash, hashType crypto.Hash) (hash.Hash, error) {
+	m, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, errors.New("seeded hash must implement encoding.BinaryMarshaler")
+	}
+	state, err := m.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal seeded hash state")
+	}
+	clone, err := newHasher(hashType)
+	if err != nil {
+		return nil, err
+	}
+	if err = clone.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, errors.Wrap(err, "seeded hash does not match ChecksumHash")
+	}
+	return clone, nil
+}
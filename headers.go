@@ -0,0 +1,31 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import "net/http"
+
+// setRequestHeaders sets `Options.Headers` on req, before any headers set by the download
+// itself so that those take precedence.
+func setRequestHeaders(req *http.Request, options Options) {
+	for name, values := range options.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			if len(values) > 0 {
+				req.Host = values[0]
+			}
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+	}
+}
@@ -0,0 +1,109 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IfExists is the policy for downloading to a `dest` file that already exists.
+type IfExists int
+
+const (
+	// IfExistsOverwrite downloads and replaces `dest`.
+	IfExistsOverwrite IfExists = iota
+	// IfExistsSkip skips the download if `dest` exists, whatever its contents.
+	IfExistsSkip
+	// IfExistsSkipIfChecksumMatches skips the download if `dest` exists and matches the
+	// checksum, which must be configured, and downloads and replaces it otherwise.
+	IfExistsSkipIfChecksumMatches
+)
+
+// checkExisting returns true if the download of src to dest should be skipped according to
+// `FileOptions.IfExists`, along with the result of the skipped download.
+func checkExisting(ctx context.Context, src *url.URL, dest string, options FileOptions) (bool, Result, error) {
+	if options.IfExists == IfExistsOverwrite {
+		return false, Result{}, nil
+	}
+	if options.DestTemplate {
+		return false, Result{}, errors.New("IfExists cannot be combined with DestTemplate")
+	}
+	if options.IfExists == IfExistsSkipIfChecksumMatches {
+		if strings.TrimSpace(options.Checksum) == "" {
+			return false, Result{}, errors.New("IfExistsSkipIfChecksumMatches requires a checksum")
+		}
+		if err := checkDestChecksum(options, "IfExistsSkipIfChecksumMatches"); err != nil {
+			return false, Result{}, err
+		}
+	}
+
+	f, err := os.Open(dest)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, Result{}, nil
+		}
+		return false, Result{}, errors.Wrap(err, "failed to open existing destination file")
+	}
+	defer func() { _ = f.Close() }() // #nosec
+	if options.IfExists == IfExistsSkip {
+		return true, Result{Path: dest, Skipped: true}, nil
+	}
+
+	checksumFilename := destChecksumFilename(src, options)
+	httpClient := getHTTPClient(options.Options)
+	cv, err := createValidator(ctx, options.ChecksumHash, httpClient, options.Checksum, checksumFilename, options.Options, options.ProgressBars, nil, false)
+	if err != nil {
+		return false, Result{}, errors.Wrap(err, "failed to create validator")
+	}
+	acceptChecksums(cv, options.AcceptableChecksums)
+	if len(options.Checksums) > 0 {
+		if cv, err = newMultiValidator(ctx, cv, httpClient, checksumFilename, options.Options); err != nil {
+			return false, Result{}, err
+		}
+	}
+	if _, err = io.Copy(cv, f); err != nil || cv.validate() != nil {
+		return false, Result{}, nil
+	}
+	return true, Result{Path: dest, ChecksumVerified: true, Skipped: true}, nil
+}
+
+// checkDestChecksum returns an error if the checksum of options can't be verified against an
+// existing `dest`, which holds the decoded bytes if decompressing. name is the option that
+// requires the verification.
+func checkDestChecksum(options FileOptions, name string) error {
+	decoded := options.Decompress != DecompressNone || options.DecodeContentEncoding
+	if decoded && !options.ChecksumOfDecompressed {
+		// The checksum is of the downloaded bytes, which aren't kept.
+		return errors.Errorf("%s requires ChecksumOfDecompressed when decompressing", name)
+	}
+	return nil
+}
+
+// destChecksumFilename returns the file name to look up the checksum of an existing `dest`
+// under, which is the decompressed name of src if decompressing.
+func destChecksumFilename(src *url.URL, options FileOptions) string {
+	name := path.Base(src.Path)
+	if options.Decompress != DecompressNone || options.DecodeContentEncoding {
+		name = decompressedName(name, options.Decompress)
+	}
+	return name
+}
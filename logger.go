@@ -0,0 +1,48 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"crypto"
+	"sync"
+)
+
+// Logger receives advisory messages about downloads. `*log.Logger` satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+var weakHashNames = map[crypto.Hash]string{
+	crypto.MD5:  "MD5",
+	crypto.SHA1: "SHA1",
+}
+
+// weakHashWarned records the weak hashes that have already been warned about.
+var weakHashWarned sync.Map
+
+// warnWeakHash logs a warning the first time a cryptographically weak ChecksumHash is used.
+func warnWeakHash(options Options) {
+	if options.Logger == nil || options.DisableWeakHashWarning || options.Checksum == "" {
+		return
+	}
+	name, weak := weakHashNames[options.ChecksumHash]
+	if !weak {
+		return
+	}
+	if _, warned := weakHashWarned.LoadOrStore(options.ChecksumHash, true); warned {
+		return
+	}
+	options.Logger.Printf("warning: %s is cryptographically weak, consider validating downloads with SHA256 or SHA512 instead", name)
+}
@@ -0,0 +1,87 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTooLarge is returned (wrapped) when a download is larger than `Options.MaxBytes`.
+var ErrTooLarge = errors.New("download too large")
+
+// ToBytes downloads the specified `src` URL into memory using the specified `Options`.
+// Callers should set `Options.MaxBytes` when downloading from untrusted sources, as the whole
+// download is otherwise held in memory however large it is.
+func ToBytes(src string, options Options) ([]byte, error) {
+	wrap := takeWrapError(&options)
+	u, err := parseSrc(src, options.Vars)
+	if err != nil {
+		return nil, wrapError(wrap, "ToBytes", err)
+	}
+	var sink byteSink
+	if _, err = fromURL(context.Background(), u, &sink, options); err != nil {
+		return nil, wrapError(wrap, "ToBytes", err)
+	}
+	return sink.Bytes(), nil
+}
+
+// byteSink is the in-memory destination of ToBytes.
+type byteSink struct {
+	bytes.Buffer
+}
+
+// grow preallocates the buffer for a response of contentLength bytes, capped at maxBytes.
+func (s *byteSink) grow(contentLength, maxBytes int64) {
+	if maxBytes > 0 && contentLength > maxBytes {
+		contentLength = maxBytes
+	}
+	if contentLength > 0 {
+		s.Grow(int(contentLength))
+	}
+}
+
+// maxBytesReader fails once more than n bytes have been read from r.
+type maxBytesReader struct {
+	r io.Reader
+	n int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > m.n+1 {
+		p = p[:m.n+1]
+	}
+	n, err := m.r.Read(p)
+	if int64(n) > m.n {
+		return int(m.n), errors.Wrapf(ErrTooLarge, "exceeded maximum of %d bytes", m.n)
+	}
+	m.n -= int64(n)
+	return n, err
+}
+
+// checkMinBytes returns an error if fewer than min bytes were written.
+func checkMinBytes(written, min int64) error {
+	switch {
+	case written >= min:
+		return nil
+	case written == 0:
+		return errors.Wrapf(ErrEmptyResponse, "expected at least %d bytes", min)
+	default:
+		return errors.Wrapf(ErrShortDownload, "received %d bytes, expected at least %d bytes", written, min)
+	}
+}
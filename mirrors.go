@@ -0,0 +1,149 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"io"
+	"net/url"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// FromURLs downloads from each of the specified `srcs` mirrors in order to `w` writer using
+// the specified `Options`, stopping at the first that succeeds or reports that the resource
+// is not modified, see `Options.IfNoneMatch`. The returned `Result` records the mirror used
+// and the requests made to each mirror tried. As bytes written to `w` cannot be taken back,
+// the next mirror is only tried if nothing was written to `w` by the failed one. The
+// returned error aggregates the errors of all mirrors tried.
+func FromURLs(srcs []*url.URL, w io.Writer, options Options) (Result, error) {
+	mirrors := make([]Mirror, len(srcs))
+	for i, src := range srcs {
+		mirrors[i] = Mirror{URL: src}
+	}
+	return FromMirrors(mirrors, w, options)
+}
+
+// Mirror is a URL to download from with FromMirrors, along with any settings specific to it.
+type Mirror struct {
+	// URL is the URL of the mirror.
+	URL *url.URL
+	// AcceptStatus overrides `Options.AcceptStatus` for this mirror, e.g. for a fallback bucket
+	// that serves complete downloads with a 206 status code.
+	AcceptStatus func(statusCode int) bool
+}
+
+// FromMirrors is the same as FromURLs but allows settings to be specified per mirror.
+func FromMirrors(mirrors []Mirror, w io.Writer, options Options) (Result, error) {
+	wrap := takeWrapError(&options)
+	result, err := fromMirrors(context.Background(), mirrors, w, options)
+	return result, wrapError(wrap, "FromURLs", err)
+}
+
+func fromMirrors(ctx context.Context, mirrors []Mirror, w io.Writer, options Options) (Result, error) {
+	ctx, cancel := withTimeout(ctx, &options)
+	defer cancel()
+	if len(mirrors) == 0 {
+		return Result{}, errors.New("no URLs to download from")
+	}
+
+	var (
+		result Result
+		res    *multierror.Error
+	)
+	for _, mirror := range mirrors {
+		src := mirror.URL
+		mirrorOptions := options
+		if mirror.AcceptStatus != nil {
+			mirrorOptions.AcceptStatus = mirror.AcceptStatus
+		}
+		cw := &countingWriter{w: w}
+		r, err := fromURL(ctx, src, cw, mirrorOptions)
+		result.Attempts += r.Attempts
+		result.Mirrors = append(result.Mirrors, MirrorAttempt{URL: src, Attempts: r.Attempts, Err: err})
+		if err == nil {
+			r.Attempts, r.Mirrors, r.MirrorUsed = result.Attempts, result.Mirrors, src
+			return r, nil
+		}
+		res = multierror.Append(res, errors.Wrapf(err, "failed to download %s", src))
+		if errors.Is(err, ErrNotModified) {
+			return result, res
+		}
+		if cw.n > 0 {
+			return result, errors.Wrap(res, "cannot try next mirror after writing to writer")
+		}
+	}
+	return result, res.ErrorOrNil()
+}
+
+// ToFileMulti downloads from each of the specified `srcs` mirrors in order to `dest` file
+// using the specified `FileOptions`, stopping at the first that succeeds or reports that the
+// resource is not modified. Unlike FromURLs, the next mirror is tried whatever the failure,
+// including a checksum mismatch, as the download is only moved to `dest` once it has
+// succeeded. The returned `Result` records the mirror used and the requests made to each
+// mirror tried. The returned error aggregates the errors of all mirrors tried.
+func ToFileMulti(srcs []string, dest string, options FileOptions) (Result, error) {
+	wrap := takeWrapError(&options.Options)
+	result, err := toFileMulti(context.Background(), srcs, dest, options)
+	return result, wrapError(wrap, "ToFileMulti", err)
+}
+
+func toFileMulti(ctx context.Context, srcs []string, dest string, options FileOptions) (Result, error) {
+	ctx, cancel := withTimeout(ctx, &options.Options)
+	defer cancel()
+	if len(srcs) == 0 {
+		return Result{}, errors.New("no URLs to download from")
+	}
+	urls := make([]*url.URL, len(srcs))
+	for i, src := range srcs {
+		u, err := parseSrc(src, options.Vars)
+		if err != nil {
+			return Result{}, err
+		}
+		urls[i] = u
+	}
+
+	var (
+		result Result
+		res    *multierror.Error
+	)
+	for _, src := range urls {
+		r, err := toFileURL(ctx, src, dest, options)
+		result.Attempts += r.Attempts
+		result.Mirrors = append(result.Mirrors, MirrorAttempt{URL: src, Attempts: r.Attempts, Err: err})
+		if err == nil {
+			r.Attempts, r.Mirrors, r.MirrorUsed = result.Attempts, result.Mirrors, src
+			return r, nil
+		}
+		res = multierror.Append(res, errors.Wrapf(err, "failed to download %s", src))
+		if ctx.Err() != nil || errors.Is(err, ErrNotModified) {
+			break
+		}
+	}
+	return result, res.ErrorOrNil()
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
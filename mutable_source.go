@@ -0,0 +1,79 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrResourceChanged is returned (wrapped) when `Options.MutableSourceGuard` is set and the
+// resource was modified while it was being downloaded.
+var ErrResourceChanged = errors.New("resource changed during download")
+
+// checkUnchanged makes a conditional HEAD request for the resource downloaded in resp and
+// fails if its ETag or Last-Modified time has changed since. Resources served without
+// either can't be checked and are assumed unchanged.
+func checkUnchanged(ctx context.Context, client *http.Client, resp *http.Response, options Options) error {
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodHead, resp.Request.URL.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	setRequestHeaders(req, options)
+	req = req.WithContext(ctx)
+	if options.Accept != "" {
+		req.Header.Set("Accept", options.Accept)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	if options.SignRequest != nil {
+		if err = options.SignRequest(req); err != nil {
+			return errors.Wrap(err, "failed to sign request")
+		}
+	}
+	head, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.Wrap(err, "failed to check whether resource changed")
+	}
+	_ = head.Body.Close() // #nosec
+
+	switch head.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+		// The server may ignore conditional headers, so compare them directly.
+		if head.Header.Get("ETag") != etag || head.Header.Get("Last-Modified") != lastModified {
+			return errors.Wrapf(ErrResourceChanged, "ETag %q, Last-Modified %q changed to ETag %q, Last-Modified %q",
+				etag, lastModified, head.Header.Get("ETag"), head.Header.Get("Last-Modified"))
+		}
+		return nil
+	default:
+		return errors.Errorf("failed to check whether resource changed: received status code %d", head.StatusCode)
+	}
+}
@@ -0,0 +1,77 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ErrOffline is returned (wrapped) when Options.Offline is set and the download can't be
+// satisfied without making a network request.
+var ErrOffline = errors.New("offline")
+
+// offlineClient is used in offline mode to guarantee no network requests are made.
+var offlineClient = &http.Client{Transport: offlineTransport{}}
+
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errors.Wrapf(ErrOffline, "refusing to fetch %s", req.URL)
+}
+
+// verifyOffline checks that dest exists and, if a checksum is configured, that it matches
+// the checksum. The checksum must be a hex string or a local file path. If decompressing, the
+// checksum must be of the decompressed bytes and is looked up under the decompressed name.
+func verifyOffline(ctx context.Context, src *url.URL, dest string, fileOptions FileOptions) (Result, error) {
+	options := fileOptions.Options
+	if options.Checksum != "" {
+		if err := checkDestChecksum(fileOptions, "Offline"); err != nil {
+			return Result{}, err
+		}
+	}
+	f, err := os.Open(dest)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Result{}, errors.Wrapf(ErrOffline, "%s does not exist", dest)
+		}
+		return Result{}, errors.Wrap(err, "failed to open destination file")
+	}
+	defer func() { _ = f.Close() }() // #nosec
+
+	warnWeakHash(options)
+	validator, err := createValidator(ctx, options.ChecksumHash, offlineClient, options.Checksum, destChecksumFilename(src, fileOptions), options, nil, nil, false)
+	if err != nil {
+		return Result{}, err
+	}
+	acceptChecksums(validator, options.AcceptableChecksums)
+	diagnostics, err := newChecksumDiagnostics(options.DiagnosticHash)
+	if err != nil {
+		return Result{}, err
+	}
+	if _, err = io.Copy(io.MultiWriter(validator, diagnostics), f); err != nil {
+		return Result{}, errors.Wrapf(ErrOffline, "%s does not match checksum: %v", dest, err)
+	}
+	if err = validator.validate(); err != nil {
+		return Result{}, errors.Wrapf(ErrOffline, "%s does not match checksum: %v", dest, diagnostics.annotate(err))
+	}
+	_, skipped := validator.(*noopValidator)
+	return Result{ChecksumVerified: !skipped}, nil
+}
@@ -0,0 +1,337 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	pb "gopkg.in/cheggaaa/pb.v1"
+)
+
+// parallelSupported returns true if the download to a file configured by options can be
+// split into ranges downloaded concurrently. Options that need to see the response as a
+// single stream, or that limit its rate, are only supported by sequential downloads.
+func parallelSupported(options FileOptions) bool {
+	return options.Concurrency > 1 &&
+		!options.sequential &&
+		!options.Resume &&
+		!options.DestTemplate &&
+		options.Decompress == DecompressNone &&
+		!options.DecodeContentEncoding &&
+		!options.VerifyStoreChecksumHeader &&
+		!options.VerifyContentMD5 &&
+		len(options.ChunkChecksums) == 0 &&
+		options.ChecksumSeed == nil &&
+		options.URLRefresh == nil &&
+		options.conditional == nil &&
+		!options.MutableSourceGuard &&
+		options.MinThroughput == 0 &&
+		options.MaxBytesPerSecond == 0 &&
+		options.Precheck == nil
+}
+
+// downloadParallel downloads src to f in `Options.Concurrency` ranges written concurrently
+// at their offsets, then validates the checksum over the assembled file. It returns false,
+// having written nothing, if the server doesn't advertise support for Range requests and the
+// Content-Length, so that the download falls back to a single stream.
+func downloadParallel(ctx context.Context, src *url.URL, f *os.File, options Options, fileHashers []*checksumFileHasher) (Result, bool, error) {
+	httpClient := getHTTPClient(options)
+	head, err := headForRanges(ctx, httpClient, src, options)
+	if err != nil || head == nil {
+		return Result{}, false, err
+	}
+	size := head.ContentLength
+	if options.MaxBytes > 0 && size > options.MaxBytes {
+		return Result{}, true, errors.Wrapf(ErrTooLarge, "size %d exceeds maximum of %d bytes", size, options.MaxBytes)
+	}
+	if err = f.Truncate(size); err != nil {
+		return Result{}, true, errors.Wrap(err, "failed to allocate temp file")
+	}
+
+	var bar *pb.ProgressBar
+	if options.ProgressBars != nil {
+		bar = newProgressBar(size, options.ProgressBars.MaxWidth, options.ProgressBars.Writer)
+		bar.Start()
+		defer bar.Finish()
+	}
+	if options.batchProgress != nil {
+		options.batchProgress.start(size)
+	}
+	progress := &parallelProgress{fn: options.ProgressFunc, bar: bar, batch: options.batchProgress, events: options.events, total: size, clock: getClock(options)}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		res      *multierror.Error
+		attempts = 1
+	)
+	chunk := (size + int64(options.Concurrency) - 1) / int64(options.Concurrency)
+	for start := int64(0); start < size; start += chunk {
+		end := start + chunk
+		if end > size {
+			end = size
+		}
+		wg.Add(1)
+		go func(start, end int64) {
+			defer wg.Done()
+			n, err := downloadRange(ctx, httpClient, head, f, start, end, options, progress)
+			mu.Lock()
+			defer mu.Unlock()
+			attempts += n
+			if err != nil && (res == nil || !errors.Is(err, context.Canceled)) {
+				res = multierror.Append(res, errors.Wrapf(err, "failed to download bytes %d-%d", start, end-1))
+				cancel()
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	if err = res.ErrorOrNil(); err != nil {
+		return Result{Attempts: attempts}, true, err
+	}
+
+	result, err := validateFile(ctx, httpClient, src, f, size, options, fileHashers)
+	if err != nil {
+		return Result{Attempts: attempts}, true, err
+	}
+	progress.done()
+	result.Attempts = attempts
+	result.ETag = head.Header.Get("ETag")
+	if lastModified, err := http.ParseTime(head.Header.Get("Last-Modified")); err == nil {
+		result.LastModified = lastModified
+	}
+	return result, true, nil
+}
+
+// headForRanges makes a HEAD request for src, returning the response if the server supports
+// Range requests and reports the Content-Length, or nil otherwise.
+func headForRanges(ctx context.Context, httpClient *http.Client, src *url.URL, options Options) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, src.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	setRequestHeaders(req, options)
+	if options.Accept != "" {
+		req.Header.Set("Accept", options.Accept)
+	}
+	if options.SignRequest != nil {
+		if err = options.SignRequest(req); err != nil {
+			return nil, errors.Wrap(err, "failed to sign request")
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrDisallowedRedirect) || errors.Is(err, ErrTooManyRedirects) {
+			return nil, err
+		}
+		// Left for the sequential download to retry or report.
+		return nil, nil
+	}
+	_ = resp.Body.Close() // #nosec
+	if err = checkFinalHost(resp, options.AllowedFinalHosts); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 || !strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") {
+		return nil, nil
+	}
+	if options.rejectDirectories {
+		if err = checkNotDirectory(resp); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// downloadRange downloads the bytes from start up to end of the resource of head to the same
+// offsets of f, retrying if the range is cut short. It returns the number of requests made.
+func downloadRange(ctx context.Context, httpClient *http.Client, head *http.Response, f *os.File, start, end int64, options Options, progress *parallelProgress) (int, error) {
+	var requests int
+	download := func() error {
+		requests++
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, head.Request.URL.String(), nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to create request")
+		}
+		setRequestHeaders(req, options)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+		if etag := head.Header.Get("ETag"); etag != "" {
+			// Fail rather than mixing ranges of different versions of the resource.
+			req.Header.Set("If-Range", etag)
+		}
+		if options.SignRequest != nil {
+			if err = options.SignRequest(req); err != nil {
+				return errors.Wrap(err, "failed to sign request")
+			}
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return &retriableError{errors.Wrap(err, "Temporary download error")}
+		}
+		defer func() { _ = resp.Body.Close() }() // #nosec
+		if err = checkContentRange(resp, start); err != nil {
+			if resp.StatusCode == http.StatusOK && req.Header.Get("If-Range") != "" {
+				return errors.Wrap(ErrResourceChanged, "If-Range precondition failed")
+			}
+			return err
+		}
+
+		n, err := io.Copy(&offsetWriter{f: f, offset: start}, progress.reader(&contextReader{ctx, io.LimitReader(resp.Body, end-start)}))
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = ErrShortDownload
+			}
+			progress.add(-n)
+			return &retriableError{errors.Wrap(err, "failed to copy contents")}
+		}
+		if n != end-start {
+			progress.add(-n)
+			return &retriableError{errors.Wrapf(ErrShortDownload, "received %d of %d bytes", n, end-start)}
+		}
+		return nil
+	}
+	err := retryAfter(ctx, getRetries(options), options.events.retrying(download), options.RetryInterval, getClock(options))
+	return requests, err
+}
+
+// validateFile validates the checksum of the first size bytes of f and feeds them to
+// fileHashers.
+func validateFile(ctx context.Context, httpClient *http.Client, src *url.URL, f *os.File, size int64, options Options, fileHashers []*checksumFileHasher) (Result, error) {
+	filename := path.Base(src.Path)
+	cv, err := createValidator(ctx, options.ChecksumHash, httpClient, options.Checksum, filename, options, options.ProgressBars, nil, false)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "failed to create validator")
+	}
+	acceptChecksums(cv, options.AcceptableChecksums)
+	if len(options.Checksums) > 0 {
+		if cv, err = newMultiValidator(ctx, cv, httpClient, filename, options); err != nil {
+			return Result{}, err
+		}
+	}
+	diagnostics, err := newChecksumDiagnostics(options.DiagnosticHash)
+	if err != nil {
+		return Result{}, err
+	}
+	computed, err := newComputedChecksum(cv, options)
+	if err != nil {
+		return Result{}, err
+	}
+	writers := append([]io.Writer{cv, diagnostics}, computed.observers()...)
+	for _, h := range fileHashers {
+		if err = h.reset(); err != nil {
+			return Result{}, err
+		}
+		writers = append(writers, h)
+	}
+	if _, err = io.Copy(io.MultiWriter(writers...), io.NewSectionReader(f, 0, size)); err != nil {
+		return Result{}, errors.Wrap(err, "failed to read downloaded file")
+	}
+	if err = checkMinBytes(size, options.MinBytes); err != nil {
+		return Result{}, err
+	}
+	if err = cv.validate(); err != nil {
+		return Result{}, diagnostics.annotate(err)
+	}
+	_, skipped := cv.(*noopValidator)
+	result := Result{ChecksumVerified: !skipped}
+	computed.setResult(&result)
+	return result, nil
+}
+
+// parallelProgress reports the progress of all the ranges of a parallel download.
+type parallelProgress struct {
+	fn         func(downloaded, total int64)
+	bar        *pb.ProgressBar
+	batch      *jobProgress
+	events     *eventEmitter
+	total      int64
+	clock      clock
+	mu         sync.Mutex
+	downloaded int64
+	// lastEvent is the time of the last progress event.
+	lastEvent time.Time
+}
+
+func (p *parallelProgress) reader(r io.Reader) io.Reader {
+	return readerFunc(func(b []byte) (int, error) {
+		n, err := r.Read(b)
+		p.add(int64(n))
+		return n, err
+	})
+}
+
+// add adds n, which is negative for the bytes of a range being retried, to the progress.
+func (p *parallelProgress) add(n int64) {
+	if n == 0 {
+		return
+	}
+	if p.bar != nil {
+		p.bar.Add64(n)
+	}
+	if p.batch != nil {
+		p.batch.add(n)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.downloaded += n
+	if p.fn != nil {
+		p.fn(p.downloaded, p.total)
+	}
+	if now := p.clock.Now(); p.events != nil && now.Sub(p.lastEvent) >= progressEventInterval {
+		p.lastEvent = now
+		p.events.progress(p.downloaded, p.total)
+	}
+}
+
+// done reports the completed download, with the total set to the bytes downloaded.
+func (p *parallelProgress) done() {
+	if p.fn != nil {
+		p.fn(p.total, p.total)
+	}
+	p.events.progress(p.total, p.total)
+}
+
+// offsetWriter writes to f at consecutive offsets from offset.
+type offsetWriter struct {
+	f      *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// readerFunc implements io.Reader with a function.
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,95 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Peek returns up to the first `n` bytes of the specified `src` URL without downloading the
+// rest, e.g. to sniff the format of an archive before downloading it. It requests just those
+// bytes with a Range request, falling back to closing the response early if the server
+// doesn't support ranges. Fewer than `n` bytes are returned only if `src` is shorter. No
+// checksum validation or decompression is done.
+func Peek(src string, n int, options Options) ([]byte, error) {
+	b, err := peek(src, n, options)
+	return b, wrapError(options.WrapError, "Peek", err)
+}
+
+func peek(src string, n int, options Options) ([]byte, error) {
+	if n <= 0 {
+		return nil, errors.New("n must be positive")
+	}
+	u, err := parseSrc(src, options.Vars)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkScheme(u); err != nil {
+		return nil, err
+	}
+	if options.Offline {
+		return nil, errors.Wrapf(ErrOffline, "refusing to fetch %s", u)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	setRequestHeaders(req, options)
+	req.Header.Set("Range", "bytes=0-"+strconv.Itoa(n-1))
+	if options.Accept != "" {
+		req.Header.Set("Accept", options.Accept)
+	}
+	if options.SignRequest != nil {
+		if err = options.SignRequest(req); err != nil {
+			return nil, errors.Wrap(err, "failed to sign request")
+		}
+	}
+	resp, err := getHTTPClient(options).Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to peek")
+	}
+	// Closing the body before it has been read to the end also closes the connection, so the
+	// rest of the response isn't transferred if the server ignored the Range header.
+	defer func() { _ = resp.Body.Close() }() // #nosec
+
+	if err = checkFinalHost(resp, options.AllowedFinalHosts); err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPartialContent:
+		if err = checkContentRange(resp, 0); err != nil {
+			return nil, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The resource is empty.
+		return []byte{}, nil
+	default:
+		return nil, newStatusError(resp, fmt.Sprintf("expected %d or %d", http.StatusOK, http.StatusPartialContent))
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(n)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to peek")
+	}
+	return b, nil
+}
@@ -0,0 +1,81 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"crypto"
+	"io"
+)
+
+// A download is streamed from the response body to the destination in a single pass, with
+// a single copy. The body is wrapped in the following order:
+//
+//  1. the bytes received are counted and reads are aborted once the context is done,
+//  2. the rate limit is applied,
+//  3. the MinThroughput monitor is fed,
+//  4. the AppendFrom offset is skipped,
+//  5. the Precheck size limit is applied,
+//  6. progress is reported to the batch progress bar, the JSON events, the progress bar and
+//     the ProgressFunc, so that it counts bytes as received over the network,
+//  7. if ChecksumOfDecompressed is set, the body is decompressed,
+//  8. the checksum observers (the checksum validator, the mismatch diagnostics, the chunk
+//     validator and any computed checksum) are fed from a single TeeReader,
+//  9. if ChecksumOfDecompressed is not set, the body is decompressed,
+//  10. the MaxBytes limit is applied to the bytes written.
+//
+// The bytes are then copied to the destination, which is teed to any checksum file hashers.
+// A checksum file hasher for the same hash as a checksum validator that sees exactly the
+// bytes written reuses the validator's checksum rather than hashing the bytes again.
+
+// teeReader returns a reader that writes everything read from r to writers, or r itself if
+// there are no writers so that io.Copy can use any WriterTo fast path.
+func teeReader(r io.Reader, writers ...io.Writer) io.Reader {
+	switch len(writers) {
+	case 0:
+		return r
+	case 1:
+		return io.TeeReader(r, writers[0])
+	default:
+		return io.TeeReader(r, io.MultiWriter(writers...))
+	}
+}
+
+// checksumFileWriter returns a writer that writes to w and each of hashers other than shared,
+// or w itself if there are none so that io.Copy can use any ReaderFrom fast path.
+func checksumFileWriter(w io.Writer, hashers []*checksumFileHasher, shared *checksumFileHasher) io.Writer {
+	writers := []io.Writer{w}
+	for _, h := range hashers {
+		if h != shared {
+			writers = append(writers, h)
+		}
+	}
+	if len(writers) == 1 {
+		return w
+	}
+	return io.MultiWriter(writers...)
+}
+
+// sharedChecksumFileHasher returns the hasher in hashers for hashType, or nil if there is none.
+func sharedChecksumFileHasher(hashers []*checksumFileHasher, hashType crypto.Hash) *checksumFileHasher {
+	if hashType == 0 {
+		hashType = crypto.SHA256
+	}
+	for _, h := range hashers {
+		if h.hashType == hashType && !h.partial {
+			return h
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPrecheckFailed is returned (wrapped) when a response fails the checks of
+// `Options.Precheck`.
+var ErrPrecheckFailed = errors.New("precheck failed")
+
+// Precheck holds checks made against a HEAD response before downloading, so that obviously
+// wrong downloads fail without transferring any bytes. If the server doesn't support HEAD
+// requests, the same checks are made against the download response instead.
+type Precheck struct {
+	// ContentTypes lists the allowed media types of the response, e.g. `application/zip`
+	// or `text/*`. Any media type is allowed if empty.
+	ContentTypes []string
+	// MaxBytes is the maximum allowed size of the response. If the server does not report
+	// the size up front, downloads fail with an error wrapping ErrTooLarge once they exceed
+	// it. Defaults to unlimited if 0.
+	MaxBytes int64
+}
+
+// check checks the headers of resp.
+func (p *Precheck) check(resp *http.Response) error {
+	if len(p.ContentTypes) > 0 {
+		mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if err != nil {
+			return errors.Wrapf(ErrPrecheckFailed, "invalid Content-Type: %q", resp.Header.Get("Content-Type"))
+		}
+		if !p.allowsContentType(mediaType) {
+			return errors.Wrapf(ErrPrecheckFailed, "Content-Type %s is not one of %v", mediaType, p.ContentTypes)
+		}
+	}
+	if p.MaxBytes > 0 && resp.ContentLength > p.MaxBytes {
+		return errors.Wrapf(ErrPrecheckFailed, "Content-Length %d exceeds maximum of %d bytes", resp.ContentLength, p.MaxBytes)
+	}
+	return nil
+}
+
+func (p *Precheck) allowsContentType(mediaType string) bool {
+	for _, allowed := range p.ContentTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// precheck makes a HEAD request for src and checks the response. Failed HEAD requests are
+// ignored, leaving the checks to be made against the download response.
+func precheck(ctx context.Context, client *http.Client, src *url.URL, options Options) error {
+	req, err := http.NewRequest(http.MethodHead, src.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	setRequestHeaders(req, options)
+	req = req.WithContext(ctx)
+	if options.Accept != "" {
+		req.Header.Set("Accept", options.Accept)
+	}
+	if options.SignRequest != nil {
+		if err = options.SignRequest(req); err != nil {
+			return errors.Wrap(err, "failed to sign request")
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return nil
+	}
+	_ = resp.Body.Close() // #nosec
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	return options.Precheck.check(resp)
+}
@@ -0,0 +1,51 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import "io"
+
+// progressFuncReader reports the bytes read from r to `Options.ProgressFunc`.
+type progressFuncReader struct {
+	r          io.Reader
+	fn         func(downloaded, total int64)
+	downloaded int64
+	total      int64
+}
+
+// newProgressFuncReader returns r reporting to fn, starting from current bytes out of total,
+// or r itself if fn is nil. A total below zero is reported as -1 (unknown).
+func newProgressFuncReader(r io.Reader, fn func(downloaded, total int64), current, total int64) io.Reader {
+	if fn == nil {
+		return r
+	}
+	if total < 0 {
+		total = -1
+	}
+	return &progressFuncReader{r: r, fn: fn, downloaded: current, total: total}
+}
+
+func (p *progressFuncReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.downloaded += int64(n)
+		p.fn(p.downloaded, p.total)
+	}
+	return n, err
+}
+
+// done reports the completed download, with the total set to the bytes downloaded.
+func (p *progressFuncReader) done() {
+	p.fn(p.downloaded, p.downloaded)
+}
@@ -0,0 +1,95 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket of bytes holding up to a second's worth of them. Bytes are
+// taken from the bucket as they are read, waiting for it to refill if they overdraw it. It can
+// be shared by concurrent downloads, see Downloader, to limit their combined rate.
+type rateLimiter struct {
+	rate   int64
+	clock  clock
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate int64, c clock) *rateLimiter {
+	return &rateLimiter{rate: rate, clock: c, last: c.Now()}
+}
+
+// take removes n tokens from the bucket, waiting until it is no longer overdrawn.
+func (l *rateLimiter) take(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	tokens := l.tokens
+	l.mu.Unlock()
+	if tokens >= 0 {
+		return nil
+	}
+	wait := time.Duration(-tokens / float64(l.rate) * float64(time.Second))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.clock.After(wait):
+		return nil
+	}
+}
+
+// rateLimitedReader limits reads from r with a rate limiter. It wraps the response body
+// before anything else counts the bytes.
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+// newRateLimitedReader returns r limited to `Options.MaxBytesPerSecond`, or r itself if it is
+// unlimited. The rate limiter of the Downloader making the download is used if it has one.
+func newRateLimitedReader(ctx context.Context, r io.Reader, options Options) io.Reader {
+	if options.MaxBytesPerSecond <= 0 {
+		return r
+	}
+	limiter := options.rateLimiter
+	if limiter == nil {
+		limiter = newRateLimiter(options.MaxBytesPerSecond, getClock(options))
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, limiter: limiter}
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.limiter.rate {
+		p = p[:l.limiter.rate]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if waitErr := l.limiter.take(l.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,84 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDisallowedRedirect is returned (wrapped) when a server redirects to a URL that is not
+// allowed to be followed, e.g. one with a scheme other than http or https.
+var ErrDisallowedRedirect = errors.New("disallowed redirect")
+
+// ErrTooManyRedirects is returned (wrapped) when a download is redirected more times than
+// allowed by `Options.MaxRedirects`.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// ErrDisallowedHost is returned (wrapped) when a download is served from a host not listed
+// in `Options.AllowedFinalHosts`.
+var ErrDisallowedHost = errors.New("disallowed host")
+
+// defaultMaxRedirects is the number of requests after which net/http stops following
+// redirects by default, so that at most 9 redirects are followed.
+const defaultMaxRedirects = 10
+
+// checkRedirect returns a http.Client CheckRedirect func that rejects redirects to schemes
+// other than http and https and redirects beyond maxRedirects before delegating to next. If
+// next is nil, the default net/http policy applies when maxRedirects is 0.
+func checkRedirect(next func(*http.Request, []*http.Request) error, maxRedirects int) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errors.Wrapf(ErrDisallowedRedirect, "redirect to unsupported scheme: %s", req.URL.Scheme)
+		}
+		if maxRedirects < 0 {
+			return errors.Wrap(ErrTooManyRedirects, "redirects are disallowed")
+		}
+		if maxRedirects > 0 && len(via) > maxRedirects {
+			return errors.Wrapf(ErrTooManyRedirects, "stopped after %d redirects", maxRedirects)
+		}
+		if maxRedirects == 0 && next == nil && len(via) >= defaultMaxRedirects {
+			return errors.Wrapf(ErrTooManyRedirects, "stopped after %d redirects", defaultMaxRedirects)
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		return nil
+	}
+}
+
+// checkFinalHost checks that resp, after following any redirects, was served from one of
+// allowedHosts. Hosts are matched using path.Match, so `*.example.com` matches any subdomain.
+// Patterns including a port are matched against the host and port.
+func checkFinalHost(resp *http.Response, allowedHosts []string) error {
+	if len(allowedHosts) == 0 {
+		return nil
+	}
+	u := resp.Request.URL
+	for _, pattern := range allowedHosts {
+		pattern = strings.ToLower(pattern)
+		host := strings.ToLower(u.Hostname())
+		if strings.Contains(pattern, ":") {
+			host = strings.ToLower(u.Host)
+		}
+		if ok, _ := path.Match(pattern, host); ok {
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrDisallowedHost, "download served from %s", u.Host)
+}
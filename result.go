@@ -0,0 +1,75 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"crypto"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Result holds details of a successful download.
+type Result struct {
+	// ChecksumVerified is true if the download was validated against a checksum. It is false
+	// if no checksum was configured.
+	ChecksumVerified bool
+	// Checksum is the hex encoded checksum of the download. It is only set if
+	// `Options.ComputeChecksum` is set.
+	Checksum string
+	// ChecksumHash is the hash of Checksum. It is ChecksumHash, unless that is unspecified and
+	// the hash was inferred from the checksum validated against.
+	ChecksumHash crypto.Hash
+	// Attempts is the total number of requests made, including retries and, for FromURLs and
+	// ToFileMulti, requests to mirrors that failed.
+	Attempts int
+	// MirrorUsed is the URL the download succeeded from. It is only set by FromURLs and
+	// ToFileMulti.
+	MirrorUsed *url.URL
+	// Mirrors holds the outcome of each mirror tried by FromURLs and ToFileMulti, in the order
+	// they were tried.
+	Mirrors []MirrorAttempt
+	// ETag is the ETag of the downloaded resource, if the server sent one.
+	ETag string
+	// LastModified is the Last-Modified time of the downloaded resource, if the server sent one.
+	LastModified time.Time
+	// Path is the file the download was written to. It is only set when downloading to a file.
+	Path string
+	// Skipped is true if the download was skipped because the file already existed, see
+	// `FileOptions.IfExists`.
+	Skipped bool
+	// DryRun describes what the download would have done. It is only set by downloads made
+	// with `FileOptions.DryRun`, which write nothing.
+	DryRun *DryRunReport
+}
+
+// MirrorAttempt holds the outcome of downloading from a single mirror.
+type MirrorAttempt struct {
+	// URL is the URL of the mirror.
+	URL *url.URL
+	// Attempts is the number of requests made to the mirror, including retries.
+	Attempts int
+	// Err is the error that occurred downloading from the mirror, if any.
+	Err error
+}
+
+func checkStrictChecksum(options Options) error {
+	if options.StrictChecksum && strings.TrimSpace(options.Checksum) == "" && len(options.ChunkChecksums) == 0 && !options.VerifyStoreChecksumHeader && !options.VerifyContentMD5 {
+		return errors.New("checksum required: StrictChecksum is set but no checksum is configured")
+	}
+	return nil
+}
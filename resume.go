@@ -0,0 +1,195 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"crypto"
+	"encoding"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PartFileName returns the name of the partial download of `dest` kept by
+// `FileOptions.Resume`.
+func PartFileName(dest string) string {
+	return dest + ".part"
+}
+
+// partialDownload is a partial download being resumed by `FileOptions.Resume`.
+type partialDownload struct {
+	f      *os.File
+	src    *url.URL
+	offset int64
+	// etag is the ETag of the resource the bytes of f are part of, if known.
+	etag string
+	// tracker tracks the checksum of the bytes written to f, so that its state can be saved
+	// if the download fails. It is nil if no checksum is configured or computed.
+	tracker *checksumFileHasher
+}
+
+// openPartialDownload opens the partial download of dest from src, creating it if it doesn't
+// exist, and prepares options to resume it. The partial download is restarted if its resume
+// state shows that it was downloaded from another URL or the resource has changed since. The
+// checksum of the existing bytes is restored from the hash state saved by a previous attempt if
+// `FileOptions.TrustHashState` is set, and computed by reading them otherwise. fileHashers are
+// always fed the existing bytes.
+func openPartialDownload(ctx context.Context, src *url.URL, dest string, options *FileOptions, fileHashers []*checksumFileHasher) (*partialDownload, error) {
+	partPath := PartFileName(dest)
+	f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, wrapDestinationError(err, "failed to open partial download")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close() // #nosec
+		return nil, errors.Wrap(err, "failed to stat partial download")
+	}
+	p := &partialDownload{f: f, src: src, offset: fi.Size()}
+	if options.ResumeFrom > p.offset {
+		_ = f.Close() // #nosec
+		return nil, errors.Errorf("ResumeFrom offset %d is beyond the end of the partial download (%d bytes)", options.ResumeFrom, p.offset)
+	}
+
+	state, stateErr := LoadResumeState(partPath)
+	if stateErr == nil {
+		p.etag = state.ETag
+	}
+	switch {
+	case p.offset == 0:
+	case len(options.ChunkChecksums) > 0 || isSRI(options.Checksum):
+		// The existing bytes can't be validated against checksums that don't support seeding.
+		p.offset = 0
+	case stateErr == nil && !resumable(ctx, src, state, options.Options):
+		p.offset, p.etag = 0, ""
+	case options.ResumeFrom > 0:
+		p.offset = options.ResumeFrom
+	}
+
+	if strings.TrimSpace(options.Checksum) != "" || options.VerifyStoreChecksumHeader || options.VerifyContentMD5 || options.ComputeChecksum {
+		hasher, err := newHasher(options.ChecksumHash)
+		if err != nil {
+			_ = f.Close() // #nosec
+			return nil, err
+		}
+		hashType := options.ChecksumHash
+		if hashType == 0 {
+			hashType = crypto.SHA256
+		}
+		p.tracker = &checksumFileHasher{Hash: hasher, hashType: hashType, partial: true}
+	}
+
+	if p.offset > 0 {
+		var writers []io.Writer
+		for _, h := range fileHashers {
+			writers = append(writers, h.Hash)
+		}
+		if p.tracker != nil && !(options.TrustHashState && p.restoreHashState(state, stateErr)) {
+			writers = append(writers, p.tracker.Hash)
+		}
+		if len(writers) > 0 {
+			if _, err = io.Copy(io.MultiWriter(writers...), io.NewSectionReader(f, 0, p.offset)); err != nil {
+				_ = f.Close() // #nosec
+				return nil, errors.Wrap(err, "failed to read partial download")
+			}
+		}
+	}
+
+	hashers := fileHashers
+	if p.tracker != nil {
+		hashers = append(hashers, p.tracker)
+	}
+	for _, h := range hashers {
+		if p.offset > 0 {
+			if err = h.savePrefix(); err != nil {
+				_ = f.Close() // #nosec
+				return nil, err
+			}
+		} else {
+			h.prefix = nil
+		}
+	}
+
+	options.AppendFrom = p.offset
+	if p.offset > 0 {
+		options.Options.ifRange = p.etag
+		options.Options.restartIgnoredRange = true
+	}
+	next := options.Options.onResponse
+	options.Options.onResponse = func(resp *http.Response) error {
+		if etag := resp.Header.Get("ETag"); etag != "" || resp.StatusCode == http.StatusOK {
+			p.etag = etag
+		}
+		if next != nil {
+			return next(resp)
+		}
+		return nil
+	}
+	if p.offset > 0 && p.tracker != nil {
+		if options.ChecksumSeed, err = cloneHasher(p.tracker.Hash, options.ChecksumHash); err != nil {
+			_ = f.Close() // #nosec
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// restoreHashState restores the checksum of the existing bytes from the resume state, or the
+// hash state saved by SaveHashState, returning false if neither matches them.
+func (p *partialDownload) restoreHashState(state ResumeState, stateErr error) bool {
+	if stateErr == nil && state.Offset == p.offset && state.HashType == p.tracker.hashType && len(state.HashState) > 0 {
+		return p.tracker.Hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(state.HashState) == nil
+	}
+	return LoadHashState(p.f.Name(), p.tracker.Hash) == nil
+}
+
+// saveState saves the resume state of the partial download, including the checksum of its
+// bytes if they are known to match so that it can be trusted when resuming. Any stale state
+// is removed.
+func (p *partialDownload) saveState() {
+	partPath := p.f.Name()
+	_ = os.Remove(HashStateFileName(partPath)) // #nosec
+	fi, err := os.Stat(partPath)
+	if err != nil {
+		_ = os.Remove(ResumeStateFileName(partPath)) // #nosec
+		return
+	}
+	state := ResumeState{URL: redactURL(p.src), ETag: p.etag, Offset: fi.Size()}
+	if p.tracker != nil && fi.Size() == p.offset+p.tracker.written {
+		if m, ok := p.tracker.Hash.(encoding.BinaryMarshaler); ok {
+			if hashState, err := m.MarshalBinary(); err == nil {
+				state.HashType, state.HashState = p.tracker.hashType, hashState
+			}
+		}
+	}
+	_ = SaveResumeState(partPath, state) // #nosec
+}
+
+// removeState removes the resume state of the completed download.
+func (p *partialDownload) removeState() {
+	_ = os.Remove(HashStateFileName(p.f.Name()))   // #nosec
+	_ = os.Remove(ResumeStateFileName(p.f.Name())) // #nosec
+}
+
+// isSRI returns true if checksum is a Subresource Integrity string.
+func isSRI(checksum string) bool {
+	v, err := newSRIValidator(checksum)
+	return err == nil && v != nil
+}
@@ -0,0 +1,109 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// ResumeState describes a partial download kept by `FileOptions.Resume`, so that the `.part`
+// file and its resume state sidecar (see ResumeStateFileName) are enough to resume the download
+// anywhere, e.g. on another node of a distributed build cache. It is saved as JSON. It holds no
+// credentials, so it can be shared safely.
+type ResumeState struct {
+	// URL is the URL the partial download was downloaded from, without its user info, query
+	// and fragment, which may hold credentials, e.g. the signature of a signed URL.
+	URL string `json:"url"`
+	// ETag is the ETag of the resource the partial download is part of, if the server sent one.
+	ETag string `json:"etag,omitempty"`
+	// Offset is the size of the partial download.
+	Offset int64 `json:"offset"`
+	// HashType is the ChecksumHash that HashState is the state of.
+	HashType crypto.Hash `json:"hashType,omitempty"`
+	// HashState is the marshaled state of the checksum hash after consuming the partial download,
+	// if a checksum is being validated. See SaveHashState.
+	HashState []byte `json:"hashState,omitempty"`
+}
+
+// ResumeStateFileName returns the name of the file that SaveResumeState persists the resume
+// state of the partial download `partPath` to.
+func ResumeStateFileName(partPath string) string {
+	return partPath + ".resume"
+}
+
+// SaveResumeState persists `state` alongside the partial download `partPath`.
+func SaveResumeState(partPath string, state ResumeState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal resume state")
+	}
+	if err = ioutil.WriteFile(ResumeStateFileName(partPath), b, 0600); err != nil {
+		return errors.Wrap(err, "failed to write resume state")
+	}
+	return nil
+}
+
+// LoadResumeState returns the resume state saved by SaveResumeState for the partial download
+// `partPath`.
+func LoadResumeState(partPath string) (ResumeState, error) {
+	var state ResumeState
+	b, err := ioutil.ReadFile(ResumeStateFileName(partPath))
+	if err != nil {
+		return state, errors.Wrap(err, "failed to read resume state")
+	}
+	if err = json.Unmarshal(b, &state); err != nil {
+		return state, errors.Wrap(err, "failed to unmarshal resume state")
+	}
+	return state, nil
+}
+
+// resumable returns true if the partial download described by state can be resumed from src:
+// it must have been downloaded from src, ignoring its credentials and query so that a
+// refreshed signed URL matches, and the resource must still have the same ETag. The
+// ETag is checked with a HEAD request; if that fails, the ETag is left to be checked with an
+// If-Range header when resuming.
+func resumable(ctx context.Context, src *url.URL, state ResumeState, options Options) bool {
+	if state.URL != redactURL(src) {
+		return false
+	}
+	if state.ETag == "" {
+		return true
+	}
+	req, err := http.NewRequest(http.MethodHead, src.String(), nil)
+	if err != nil {
+		return true
+	}
+	setRequestHeaders(req, options)
+	req = req.WithContext(ctx)
+	if options.SignRequest != nil {
+		if err = options.SignRequest(req); err != nil {
+			return true
+		}
+	}
+	resp, err := getHTTPClient(options).Do(req)
+	if err != nil {
+		return true
+	}
+	_ = resp.Body.Close() // #nosec
+	etag := resp.Header.Get("ETag")
+	return resp.StatusCode != http.StatusOK || etag == "" || etag == state.ETag
+}
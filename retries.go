@@ -1,6 +1,7 @@
 package download
 
 import (
+	"context"
 	"time"
 
 	multierror "github.com/hashicorp/go-multierror"
@@ -14,10 +15,14 @@ func (e *retriableError) Error() string {
 	return e.err.Error()
 }
 
-func retryAfter(attempts int, callback func() error, d time.Duration) error {
+func (e *retriableError) Unwrap() error {
+	return e.err
+}
+
+func retryAfter(ctx context.Context, attempts int, callback func() error, d time.Duration, c clock) error {
 	var res *multierror.Error
 	if attempts == -1 {
-		attempts = ^int(0)
+		attempts = int(^uint(0) >> 1)
 	}
 	for i := 0; i < attempts; i++ {
 		err := callback()
@@ -28,7 +33,11 @@ func retryAfter(attempts int, callback func() error, d time.Duration) error {
 		if _, ok := err.(*retriableError); !ok {
 			return res
 		}
-		<-time.After(d)
+		select {
+		case <-c.After(d):
+		case <-ctx.Done():
+			return multierror.Append(res, ctx.Err())
+		}
 	}
 	return res.ErrorOrNil()
 }
@@ -0,0 +1,132 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnsupportedScheme is returned (wrapped) when a URL has a scheme that can't be downloaded.
+var ErrUnsupportedScheme = errors.New("unsupported scheme")
+
+// Fetcher fetches resources for a URL scheme registered with RegisterScheme. Downloads from a
+// registered scheme go through the same checksum validation, decompression, progress and
+// retry handling as HTTP downloads.
+type Fetcher interface {
+	// Fetch opens `src` for reading, returning the size of the resource or -1 if unknown.
+	// Errors are retried according to `Options.Retries`.
+	Fetch(ctx context.Context, src *url.URL) (body io.ReadCloser, size int64, err error)
+}
+
+var (
+	fetchersMu sync.RWMutex
+	fetchers   = map[string]Fetcher{}
+)
+
+// RegisterScheme registers `fetcher` to download URLs with `scheme`, e.g. `ftp`. Registering
+// a scheme again replaces its fetcher. The `http` and `https` schemes can't be registered.
+func RegisterScheme(scheme string, fetcher Fetcher) {
+	scheme = strings.ToLower(scheme)
+	if scheme == "http" || scheme == "https" {
+		panic("download: cannot register scheme " + scheme)
+	}
+	if fetcher == nil {
+		panic("download: RegisterScheme fetcher is nil")
+	}
+	fetchersMu.Lock()
+	defer fetchersMu.Unlock()
+	fetchers[scheme] = fetcher
+}
+
+func registeredFetcher(scheme string) Fetcher {
+	fetchersMu.RLock()
+	defer fetchersMu.RUnlock()
+	return fetchers[strings.ToLower(scheme)]
+}
+
+func hasRegisteredSchemes() bool {
+	fetchersMu.RLock()
+	defer fetchersMu.RUnlock()
+	return len(fetchers) > 0
+}
+
+// supportedSchemes returns the schemes that can be downloaded.
+func supportedSchemes() []string {
+	fetchersMu.RLock()
+	defer fetchersMu.RUnlock()
+	schemes := make([]string, 0, len(fetchers))
+	for scheme := range fetchers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return append([]string{"http", "https"}, schemes...)
+}
+
+// checkScheme checks that u has a scheme that can be downloaded.
+func checkScheme(u *url.URL) error {
+	schemes := supportedSchemes()
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return nil
+		}
+	}
+	if u.Scheme == "" {
+		return errors.Wrapf(ErrUnsupportedScheme, "%s has no scheme (supported schemes: %v)", u, schemes)
+	}
+	return errors.Wrapf(ErrUnsupportedScheme, "%s (supported schemes: %v)", u.Scheme, schemes)
+}
+
+// schemeTransport serves requests for registered schemes from their fetchers, passing any
+// other requests to next. Responses from fetchers are presented as `200 OK` responses to GET
+// requests so that they are handled exactly like HTTP downloads.
+type schemeTransport struct {
+	next http.RoundTripper
+}
+
+func (t *schemeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fetcher := registeredFetcher(req.URL.Scheme)
+	if fetcher == nil {
+		return t.next.RoundTrip(req)
+	}
+	resp := &http.Response{
+		Status:     "405 Method Not Allowed",
+		StatusCode: http.StatusMethodNotAllowed,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+	if req.Method != http.MethodGet {
+		return resp, nil
+	}
+	body, size, err := fetcher.Fetch(req.Context(), req.URL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch %s", req.URL)
+	}
+	resp.Status, resp.StatusCode = "200 OK", http.StatusOK
+	resp.Body, resp.ContentLength = body, size
+	return resp, nil
+}
@@ -0,0 +1,66 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// OpenSeekable downloads the specified `src` URL to a temp file using the specified
+// `Options`, validating the checksum if configured, and returns a handle to read and seek
+// over it. Closing the handle removes the temp file.
+func OpenSeekable(src string, options Options) (io.ReadSeekCloser, error) {
+	u, err := parseSrc(src, options.Vars)
+	if err != nil {
+		return nil, err
+	}
+	if options.AppendFrom > 0 {
+		return nil, errors.New("AppendFrom is not supported by OpenSeekable")
+	}
+
+	f, err := ioutil.TempFile("", "go-download-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temp file")
+	}
+	if _, err = downloadFile(context.Background(), u, f, options, nil); err != nil {
+		_ = f.Close()           // #nosec
+		_ = os.Remove(f.Name()) // #nosec
+		return nil, errors.Wrap(err, "failed to download")
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		_ = f.Close()           // #nosec
+		_ = os.Remove(f.Name()) // #nosec
+		return nil, errors.Wrap(err, "failed to seek temp file")
+	}
+	return &tempFile{File: f}, nil
+}
+
+// tempFile is a file that is removed when closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); rmErr != nil && err == nil {
+		err = errors.Wrap(rmErr, "failed to remove temp file")
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrURLExpired is returned (wrapped) when `Options.URLExpiry` has passed and there is no
+// `Options.URLRefresh` to obtain a fresh URL.
+var ErrURLExpired = errors.New("URL expired")
+
+// urlExpiryMargin is how long before URLExpiry a URL is considered expired, to allow time
+// for the request to reach the server.
+const urlExpiryMargin = 10 * time.Second
+
+// signedURL tracks the URL to request for a download, refreshing it when retrying or when
+// it is about to expire.
+type signedURL struct {
+	u       *url.URL
+	expiry  time.Time
+	refresh func() (string, error)
+	used    bool
+	clock   clock
+}
+
+func newSignedURL(u *url.URL, options Options) *signedURL {
+	return &signedURL{
+		u:       u,
+		expiry:  options.URLExpiry,
+		refresh: options.URLRefresh,
+		clock:   getClock(options),
+	}
+}
+
+// next returns the URL to use for the next request. Refreshed URLs are assumed not to
+// expire before they are used.
+func (s *signedURL) next() (*url.URL, error) {
+	now := s.clock.Now()
+	expiring := !s.expiry.IsZero() && now.Add(urlExpiryMargin).After(s.expiry)
+	switch {
+	case s.refresh != nil && (s.used || expiring):
+		raw, err := s.refresh()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to refresh URL")
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid refreshed URL")
+		}
+		s.u, s.expiry = u, time.Time{}
+	case s.refresh == nil && expiring && !now.Before(s.expiry):
+		return nil, errors.Wrapf(ErrURLExpired, "URL expired at %s", s.expiry.Format(time.RFC3339))
+	}
+	s.used = true
+	return s.u, nil
+}
@@ -0,0 +1,117 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"hash"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sriPattern matches a single Subresource Integrity hash expression, ignoring any options.
+var sriPattern = regexp.MustCompile(`^(sha256|sha384|sha512)-([A-Za-z0-9+/]+={0,2})(\?.*)?$`)
+
+var sriHashes = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+type sriDigest struct {
+	hashType crypto.Hash
+	digest   []byte
+}
+
+var _ checksumValidator = &sriValidator{}
+
+// sriValidator validates a download against a Subresource Integrity string, passing if any
+// of its alternatives match.
+type sriValidator struct {
+	digests []sriDigest
+	hashers map[crypto.Hash]hash.Hash
+}
+
+// newSRIValidator returns a validator for checksum if it is a Subresource Integrity string,
+// or nil if it isn't.
+func newSRIValidator(checksum string) (*sriValidator, error) {
+	fields := strings.Fields(checksum)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	v := &sriValidator{hashers: map[crypto.Hash]hash.Hash{}}
+	for _, field := range fields {
+		match := sriPattern.FindStringSubmatch(field)
+		if match == nil {
+			return nil, nil
+		}
+		digest, err := base64.StdEncoding.DecodeString(match[2])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid integrity digest: %s", field)
+		}
+		hashType := sriHashes[match[1]]
+		if _, ok := v.hashers[hashType]; !ok {
+			if v.hashers[hashType], err = newHasher(hashType); err != nil {
+				return nil, err
+			}
+		}
+		v.digests = append(v.digests, sriDigest{hashType: hashType, digest: digest})
+	}
+	return v, nil
+}
+
+func (v *sriValidator) Write(p []byte) (int, error) {
+	for _, h := range v.hashers {
+		_, _ = h.Write(p) // #nosec
+	}
+	return len(p), nil
+}
+
+func (v *sriValidator) validate() error {
+	sums := map[crypto.Hash][]byte{}
+	for hashType, h := range v.hashers {
+		sums[hashType] = h.Sum(nil)
+	}
+	for _, d := range v.digests {
+		if bytes.Equal(sums[d.hashType], d.digest) {
+			return nil
+		}
+	}
+	computed := make([]string, 0, len(sums))
+	for _, d := range v.digests {
+		if sum, ok := sums[d.hashType]; ok {
+			computed = append(computed, sriName(d.hashType)+"-"+base64.StdEncoding.EncodeToString(sum))
+			delete(sums, d.hashType)
+		}
+	}
+	expected := make([]string, 0, len(v.digests))
+	for _, d := range v.digests {
+		expected = append(expected, sriName(d.hashType)+"-"+base64.StdEncoding.EncodeToString(d.digest))
+	}
+	return &ChecksumMismatchError{Expected: []string{strings.Join(expected, " ")}, Actual: strings.Join(computed, " ")}
+}
+
+func sriName(hashType crypto.Hash) string {
+	for name, h := range sriHashes {
+		if h == hashType {
+			return name
+		}
+	}
+	return hashType.String()
+}
@@ -0,0 +1,68 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultStoreChecksumHeaders are the headers S3 compatible object stores report object
+// checksums in.
+var defaultStoreChecksumHeaders = map[crypto.Hash]string{
+	crypto.SHA1:   "X-Amz-Checksum-Sha1",
+	crypto.SHA256: "X-Amz-Checksum-Sha256",
+	0:             "X-Amz-Checksum-Sha256",
+}
+
+// storeChecksumHeader returns the name of the header holding the object checksum.
+func storeChecksumHeader(options Options) (string, error) {
+	if options.StoreChecksumHeader != "" {
+		return options.StoreChecksumHeader, nil
+	}
+	if name, ok := defaultStoreChecksumHeaders[options.ChecksumHash]; ok {
+		return name, nil
+	}
+	return "", errors.New("StoreChecksumHeader must be set for this ChecksumHash")
+}
+
+// storeChecksum returns the hex encoded checksum reported by the object store in resp. The
+// header value can be hex or base64 encoded.
+func storeChecksum(resp *http.Response, options Options) (string, error) {
+	name, err := storeChecksumHeader(options)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(resp.Header.Get(name))
+	if value == "" {
+		return "", errors.Errorf("missing checksum header %s", name)
+	}
+	hasher, err := newHasher(options.ChecksumHash)
+	if err != nil {
+		return "", err
+	}
+	if digest, err := hex.DecodeString(value); err == nil && len(digest) == hasher.Size() {
+		return strings.ToLower(value), nil
+	}
+	if digest, err := base64.StdEncoding.DecodeString(value); err == nil && len(digest) == hasher.Size() {
+		return hex.EncodeToString(digest), nil
+	}
+	return "", checksumFormatError("invalid checksum in header %s: %s", name, value)
+}
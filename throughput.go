@@ -0,0 +1,111 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTooSlow is returned (wrapped) when the throughput of a download stays below
+// `Options.MinThroughput` for `Options.ThroughputWindow`.
+var ErrTooSlow = errors.New("download too slow")
+
+const (
+	defaultThroughputWindow = 30 * time.Second
+	// throughputSamples is the number of samples the throughput window is divided into.
+	throughputSamples = 10
+)
+
+// throughputMonitor cancels a download whose throughput, measured over a sliding window,
+// drops below a minimum. The bytes read are sampled at regular intervals so that a
+// connection delivering no bytes at all is detected even while a read is blocked.
+type throughputMonitor struct {
+	r        io.Reader
+	read     int64
+	min      int64
+	window   time.Duration
+	clock    clock
+	cancel   context.CancelFunc
+	tooSlow  int32
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newThroughputMonitor starts monitoring reads from r, calling cancel if they are too slow.
+func newThroughputMonitor(r io.Reader, options Options, cancel context.CancelFunc) *throughputMonitor {
+	window := options.ThroughputWindow
+	if window <= 0 {
+		window = defaultThroughputWindow
+	}
+	m := &throughputMonitor{
+		r:      r,
+		min:    options.MinThroughput,
+		window: window,
+		clock:  getClock(options),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go m.watch()
+	return m
+}
+
+func (m *throughputMonitor) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	atomic.AddInt64(&m.read, int64(n))
+	return n, err
+}
+
+func (m *throughputMonitor) watch() {
+	interval := m.window / throughputSamples
+	// samples holds the total bytes read at each of the last throughputSamples intervals.
+	samples := make([]int64, 0, throughputSamples+1)
+	samples = append(samples, 0)
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.clock.After(interval):
+		}
+		samples = append(samples, atomic.LoadInt64(&m.read))
+		if len(samples) <= throughputSamples {
+			continue
+		}
+		if float64(samples[throughputSamples]-samples[0]) < float64(m.min)*m.window.Seconds() {
+			atomic.StoreInt32(&m.tooSlow, 1)
+			m.cancel()
+			return
+		}
+		samples = samples[1:]
+	}
+}
+
+// stop stops monitoring. It is safe to call more than once.
+func (m *throughputMonitor) stop() {
+	m.stopOnce.Do(func() { close(m.done) })
+}
+
+// wrapError returns an error wrapping ErrTooSlow if the monitor cancelled the download.
+func (m *throughputMonitor) wrapError(err error) error {
+	if atomic.LoadInt32(&m.tooSlow) == 0 {
+		return err
+	}
+	return errors.Wrapf(ErrTooSlow, "throughput below %d bytes/s over %s", m.min, m.window)
+}
@@ -0,0 +1,208 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Transfer is a download to a file running in the background, started by StartDownload, that
+// can be paused, resumed and cancelled.
+type Transfer struct {
+	src     string
+	dest    string
+	options FileOptions
+	total   int64
+	done    chan struct{}
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	cancel    context.CancelFunc
+	paused    bool
+	cancelled bool
+	// interrupted is set by Pause and Cancel when they cancel the running attempt, so that
+	// its error isn't taken as the result of the transfer even if it has since been resumed.
+	interrupted bool
+	err         error
+}
+
+// TransferProgress is a snapshot of the progress of a Transfer.
+type TransferProgress struct {
+	// BytesComplete is the number of bytes downloaded so far.
+	BytesComplete int64
+	// BytesTotal is the size of the download, or -1 if it isn't known yet.
+	BytesTotal int64
+	// Paused is true if the transfer is paused.
+	Paused bool
+	// Done is true if the transfer has finished, successfully or not.
+	Done bool
+}
+
+// StartDownload starts downloading the specified `src` URL to `dest` file in the background
+// using the specified `FileOptions`, returning a Transfer to control it. The download is made
+// with `FileOptions.Resume` set, so that pausing it tears down the connection and resuming it
+// continues from where it stopped with a Range request.
+func StartDownload(src, dest string, options FileOptions) *Transfer {
+	t := &Transfer{
+		src:   src,
+		dest:  dest,
+		total: -1,
+		done:  make(chan struct{}),
+	}
+	t.cond = sync.NewCond(&t.mu)
+	options.Resume = true
+	options.Options.onResponse = func(resp *http.Response) error {
+		atomic.StoreInt64(&t.total, responseSize(resp))
+		return nil
+	}
+	t.options = options
+	go t.run()
+	return t
+}
+
+func (t *Transfer) run() {
+	defer close(t.done)
+	for {
+		t.mu.Lock()
+		for t.paused && !t.cancelled {
+			t.cond.Wait()
+		}
+		if t.cancelled {
+			t.err = context.Canceled
+			t.mu.Unlock()
+			t.removePartial()
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.cancel = cancel
+		t.interrupted = false
+		t.mu.Unlock()
+
+		_, err := toFile(ctx, t.src, t.dest, t.options)
+		cancel()
+
+		t.mu.Lock()
+		t.cancel = nil
+		interrupted := err != nil && t.interrupted
+		if !interrupted {
+			t.err = err
+		}
+		t.mu.Unlock()
+		if !interrupted {
+			return
+		}
+	}
+}
+
+func (t *Transfer) removePartial() {
+	partPath := PartFileName(t.dest)
+	_ = os.Remove(partPath)                      // #nosec
+	_ = os.Remove(HashStateFileName(partPath))   // #nosec
+	_ = os.Remove(ResumeStateFileName(partPath)) // #nosec
+}
+
+// Pause pauses the transfer, closing the connection. It has no effect if the transfer is
+// already paused or has finished.
+func (t *Transfer) Pause() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.paused || t.cancelled {
+		return
+	}
+	t.paused = true
+	if t.cancel != nil {
+		t.interrupted = true
+		t.cancel()
+	}
+}
+
+// Resume resumes a paused transfer from where it stopped. It has no effect if the transfer
+// isn't paused.
+func (t *Transfer) Resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused = false
+	t.cond.Broadcast()
+}
+
+// Cancel stops the transfer and removes the partial download. Wait then returns
+// context.Canceled. It has no effect if the transfer has finished.
+func (t *Transfer) Cancel() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cancelled = true
+	if t.cancel != nil {
+		t.interrupted = true
+		t.cancel()
+	}
+	t.cond.Broadcast()
+}
+
+// Done returns a channel that is closed when the transfer has finished.
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait waits for the transfer to finish, returning any error it failed with.
+func (t *Transfer) Wait() error {
+	<-t.done
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Progress returns a snapshot of the progress of the transfer.
+func (t *Transfer) Progress() TransferProgress {
+	p := TransferProgress{BytesTotal: atomic.LoadInt64(&t.total)}
+	select {
+	case <-t.done:
+		p.Done = true
+	default:
+	}
+	t.mu.Lock()
+	p.Paused = t.paused && !p.Done
+	failed := t.err != nil
+	t.mu.Unlock()
+
+	path := PartFileName(t.dest)
+	if p.Done && !failed {
+		path = t.dest
+	}
+	if fi, err := os.Stat(path); err == nil {
+		p.BytesComplete = fi.Size()
+	}
+	return p
+}
+
+// responseSize returns the size of the whole resource resp is for, which is the total in the
+// Content-Range of partial responses.
+func responseSize(resp *http.Response) int64 {
+	if resp.StatusCode == http.StatusPartialContent {
+		contentRange := resp.Header.Get("Content-Range")
+		if i := strings.LastIndex(contentRange, "/"); i >= 0 {
+			if size, err := strconv.ParseInt(contentRange[i+1:], 10, 64); err == nil {
+				return size
+			}
+		}
+		return -1
+	}
+	return resp.ContentLength
+}
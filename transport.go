@@ -0,0 +1,60 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// transportKey identifies a clone of a transport with connection pooling settings applied.
+type transportKey struct {
+	base                *http.Transport
+	idleConnTimeout     time.Duration
+	maxIdleConnsPerHost int
+}
+
+// pooledTransports caches the transports cloned by pooledTransport, so that downloads with the
+// same settings share a connection pool.
+var pooledTransports sync.Map
+
+// pooledTransport returns rt with `Options.IdleConnTimeout` and `Options.MaxIdleConnsPerHost`
+// applied. rt is returned unchanged if neither is set or it isn't an `*http.Transport`.
+func pooledTransport(rt http.RoundTripper, options Options) http.RoundTripper {
+	if options.IdleConnTimeout == 0 && options.MaxIdleConnsPerHost == 0 {
+		return rt
+	}
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	base, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+	key := transportKey{base, options.IdleConnTimeout, options.MaxIdleConnsPerHost}
+	if t, ok := pooledTransports.Load(key); ok {
+		return t.(*http.Transport)
+	}
+	t := base.Clone()
+	if options.IdleConnTimeout != 0 {
+		t.IdleConnTimeout = options.IdleConnTimeout
+	}
+	if options.MaxIdleConnsPerHost != 0 {
+		t.MaxIdleConnsPerHost = options.MaxIdleConnsPerHost
+	}
+	actual, _ := pooledTransports.LoadOrStore(key, t)
+	return actual.(*http.Transport)
+}
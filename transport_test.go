@@ -0,0 +1,47 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPooledTransport(t *testing.T) {
+	base := &http.Transport{IdleConnTimeout: time.Second}
+	if rt := pooledTransport(base, Options{}); rt != base {
+		t.Fatal("expected transport to be unchanged")
+	}
+
+	options := Options{IdleConnTimeout: time.Hour, MaxIdleConnsPerHost: 10}
+	rt := pooledTransport(base, options)
+	pooled, ok := rt.(*http.Transport)
+	if !ok || pooled == base {
+		t.Fatalf("expected a cloned transport, got %v", rt)
+	}
+	if pooled.IdleConnTimeout != time.Hour || pooled.MaxIdleConnsPerHost != 10 {
+		t.Fatalf("settings not applied: %v, %d", pooled.IdleConnTimeout, pooled.MaxIdleConnsPerHost)
+	}
+	if base.IdleConnTimeout != time.Second || base.MaxIdleConnsPerHost != 0 {
+		t.Fatal("expected base transport to be untouched")
+	}
+	if pooledTransport(base, options) != rt {
+		t.Fatal("expected cloned transport to be reused")
+	}
+	if pooledTransport(nil, options) == rt {
+		t.Fatal("expected a different clone of the default transport")
+	}
+}
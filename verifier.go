@@ -0,0 +1,64 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ErrVerificationFailed is returned (wrapped) when `FileOptions.Verifier` rejects a download.
+var ErrVerificationFailed = errors.New("verification failed")
+
+// Verifier verifies the provenance of a completed download, e.g. against a detached signature
+// made with GPG, minisign or cosign. See the gpg subpackage for a GPG implementation.
+type Verifier interface {
+	// Verify returns an error if `content`, the complete download, fails verification. `meta`
+	// is the Result of the download, whose Path is the file it will be moved to.
+	Verify(content io.Reader, meta Result) error
+}
+
+// verifyFile verifies the contents of path with v.
+func verifyFile(v Verifier, path string, meta Result) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open download for verification")
+	}
+	defer func() { _ = f.Close() }() // #nosec
+	if err = v.Verify(f, meta); err != nil {
+		return &verificationError{err}
+	}
+	return nil
+}
+
+// verificationError is an error returned by a Verifier. It matches ErrVerificationFailed while
+// keeping the error of the Verifier in the chain.
+type verificationError struct {
+	err error
+}
+
+func (e *verificationError) Error() string {
+	return ErrVerificationFailed.Error() + ": " + e.err.Error()
+}
+
+func (e *verificationError) Is(target error) bool {
+	return target == ErrVerificationFailed
+}
+
+func (e *verificationError) Unwrap() error {
+	return e.err
+}
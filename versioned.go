@@ -0,0 +1,84 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ToVersionedFile downloads the specified `src` URL to `versionedDest` file using the specified
+// `FileOptions`, then atomically points the `symlinkPath` symlink at it, e.g. to download
+// `app-v2` and switch `current` over to it. The symlink is replaced by creating a new symlink
+// next to it and renaming it over the old one, so `symlinkPath` always resolves to either the
+// previous or the new version. If the symlink can't be updated, `versionedDest` is removed
+// unless the partial download would have been kept. With `FileOptions.DryRun`, the symlink
+// isn't updated.
+func ToVersionedFile(src, versionedDest, symlinkPath string, options FileOptions) error {
+	wrap := takeWrapError(&options.Options)
+	return wrapError(wrap, "ToVersionedFile", toVersionedFile(src, versionedDest, symlinkPath, options))
+}
+
+func toVersionedFile(src, versionedDest, symlinkPath string, options FileOptions) error {
+	if options.DestTemplate {
+		return errors.New("DestTemplate cannot be used with ToVersionedFile")
+	}
+	result, err := toFile(context.Background(), src, versionedDest, options)
+	if err != nil {
+		return err
+	}
+	if result.DryRun != nil {
+		// Nothing was written, so the symlink would be left dangling.
+		return nil
+	}
+	if err = updateSymlink(versionedDest, symlinkPath); err != nil {
+		if !keepPartial(err, options) {
+			_ = os.Remove(versionedDest) // #nosec
+		}
+		return err
+	}
+	return nil
+}
+
+// updateSymlink atomically points symlinkPath at target. The symlink is relative if target can
+// be expressed relative to the directory of symlinkPath.
+func updateSymlink(target, symlinkPath string) error {
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve versioned file path")
+	}
+	absLink, err := filepath.Abs(symlinkPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve symlink path")
+	}
+	linkTarget, err := filepath.Rel(filepath.Dir(absLink), absTarget)
+	if err != nil {
+		linkTarget = absTarget
+	}
+
+	tmpLink := symlinkPath + ".tmp"
+	_ = os.Remove(tmpLink) // #nosec
+	if err = os.Symlink(linkTarget, tmpLink); err != nil {
+		return errors.Wrap(err, "failed to create symlink")
+	}
+	if err = os.Rename(tmpLink, symlinkPath); err != nil {
+		_ = os.Remove(tmpLink) // #nosec
+		return errors.Wrap(err, "failed to replace symlink")
+	}
+	return nil
+}
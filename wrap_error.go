@@ -0,0 +1,32 @@
+//    Copyright 2016 Red Hat, Inc.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package download
+
+// takeWrapError returns `Options.WrapError` and clears it, so that operations made on behalf of
+// the caller return their errors unwrapped and the hook is only applied once, to the error
+// returned to the caller.
+func takeWrapError(options *Options) func(op string, err error) error {
+	wrap := options.WrapError
+	options.WrapError = nil
+	return wrap
+}
+
+// wrapError applies wrap, if set, to a non-nil err returned by op.
+func wrapError(wrap func(op string, err error) error, op string, err error) error {
+	if err == nil || wrap == nil {
+		return err
+	}
+	return wrap(op, err)
+}